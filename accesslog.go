@@ -0,0 +1,124 @@
+package httpize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the format of lines written to Options.AccessLog.
+type AccessLogFormat int
+
+const (
+	// Common Log Format followed by the method name and duration in
+	// seconds. This is the default.
+	AccessLogCommon AccessLogFormat = iota
+	// Combined Log Format, Common with the Referer and User-Agent headers,
+	// followed by the method name and duration in seconds
+	AccessLogCombined
+	// A JSON object with the fields time, method, remote_addr, request,
+	// status, bytes and duration (in seconds)
+	AccessLogJSON
+)
+
+// serializes writes to access logs, so lines aren't mixed up
+var accessLogMu sync.Mutex
+
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	RemoteAddr string    `json:"remote_addr"`
+	Request    string    `json:"request"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Duration   float64   `json:"duration"`
+}
+
+// accessLog wraps next to write a line for each request to Options.AccessLog.
+// The values of query parameters that are secret, arguments with
+// ArgDef.Redact set, API keys, CSRF tokens and URL signatures, are replaced
+// with REDACTED.
+func (h *handler) accessLog(next http.Handler) http.Handler {
+	w, format := h.options.AccessLog, h.options.AccessLogFormat
+	secret := make(map[string]bool)
+	for i := range h.argBuilders {
+		if h.argBuilders[i].redact {
+			secret[h.argBuilders[i].key] = true
+		}
+	}
+	if a, ok := h.options.Authenticator.(QueryAuthenticator); ok && a.QueryParam() != "" {
+		secret[a.QueryParam()] = true
+	}
+	if h.options.CSRF != nil {
+		_, _, param := h.options.CSRF.names()
+		secret[param] = true
+	}
+	if h.options.SignedURLs != nil {
+		secret["signature"] = true
+	}
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: resp}
+		defer func() {
+			if sw.code == 0 {
+				sw.code = http.StatusOK
+			}
+			e := accessLogEntry{
+				Time:       start,
+				Method:     h.name,
+				RemoteAddr: req.RemoteAddr,
+				Request:    req.Method + " " + redactedURI(req.URL, secret) + " " + req.Proto,
+				Status:     sw.code,
+				Bytes:      sw.size,
+				Duration:   time.Since(start).Seconds(),
+			}
+			writeAccessLog(w, format, e, req)
+		}()
+		next.ServeHTTP(sw, req)
+	})
+}
+
+// redactedURI returns the request URI of u with the values of the query
+// parameters in secret replaced with REDACTED.
+func redactedURI(u *url.URL, secret map[string]bool) string {
+	if u.RawQuery == "" || len(secret) == 0 {
+		return u.RequestURI()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, p := range params {
+		k, _, _ := strings.Cut(p, "=")
+		if key, err := unescape(k); err != nil || secret[key] {
+			params[i] = k + "=REDACTED"
+		}
+	}
+	return u.EscapedPath() + "?" + strings.Join(params, "&")
+}
+
+func writeAccessLog(w io.Writer, format AccessLogFormat, e accessLogEntry, req *http.Request) {
+	var line []byte
+	if format == AccessLogJSON {
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	} else {
+		host := e.RemoteAddr
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		line = fmt.Appendf(nil, "%s - - [%s] %s %d %d", host,
+			e.Time.Format("02/Jan/2006:15:04:05 -0700"), strconv.Quote(e.Request), e.Status, e.Bytes)
+		if format == AccessLogCombined {
+			line = fmt.Appendf(line, " %s %s", strconv.Quote(req.Referer()), strconv.Quote(req.UserAgent()))
+		}
+		line = fmt.Appendf(line, " %s %.6f\n", e.Method, e.Duration)
+	}
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	w.Write(line)
+}
@@ -0,0 +1,74 @@
+package httpize
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var accessLog bytes.Buffer
+
+func Logged(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return strings.NewReader("hello"), nil, nil
+}
+
+var _ = HandleWithOptions("/Logged/Common", CallerFunc(Logged), &Options{AccessLog: &accessLog})
+var _ = HandleWithOptions("/Logged/Combined", CallerFunc(Logged), &Options{AccessLog: &accessLog, AccessLogFormat: AccessLogCombined})
+var _ = HandleWithOptions("/Logged/JSON", CallerFunc(Logged), &Options{AccessLog: &accessLog, AccessLogFormat: AccessLogJSON})
+
+func TestAccessLog(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		want    *regexp.Regexp
+	}{
+		{"/Logged/Common", regexp.MustCompile(`^192\.0\.2\.1 - - \[[^]]+\] "GET /Logged/Common\?x=1 HTTP/1\.1" 400 \d+ Common \d+\.\d{6}\n$`)},
+		{"/Logged/Combined", regexp.MustCompile(`^192\.0\.2\.1 - - \[[^]]+\] "GET /Logged/Combined HTTP/1\.1" 200 5 "http://ref/" "test" Combined \d+\.\d{6}\n$`)},
+	} {
+		accessLog.Reset()
+		url := "http://host" + c.pattern
+		if c.pattern == "/Logged/Common" {
+			url += "?x=1"
+		}
+		request := httptest.NewRequest("GET", url, nil)
+		request.Header.Set("Referer", "http://ref/")
+		request.Header.Set("User-Agent", "test")
+		GetHandlerForPattern(c.pattern).ServeHTTP(httptest.NewRecorder(), request)
+		if !c.want.MatchString(accessLog.String()) {
+			t.Errorf("got %q", accessLog.String())
+		}
+	}
+
+	accessLog.Reset()
+	GetHandlerForPattern("/Logged/JSON").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://host/Logged/JSON", nil))
+	var e map[string]interface{}
+	if err := json.Unmarshal(accessLog.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e["method"] != "JSON" || e["status"] != 200.0 || e["bytes"] != 5.0 || e["remote_addr"] != "192.0.2.1:1234" {
+		t.Errorf("got %v", e)
+	}
+}
+
+var _ = HandleWithOptions("/Logged/Keyed", CallerFunc(Logged), &Options{
+	AccessLog:     &accessLog,
+	Authenticator: &APIKeyAuth{Param: "api_key", Lookup: APIKeys(APIKey{Key: "k1", Principal: User{ID: "service1"}})},
+})
+
+func TestAccessLogRedacted(t *testing.T) {
+	accessLog.Reset()
+	request := httptest.NewRequest("GET", "http://host/Logged/Keyed?api_key=k1", nil)
+	GetHandlerForPattern("/Logged/Keyed").ServeHTTP(httptest.NewRecorder(), request)
+	if !strings.Contains(accessLog.String(), `"GET /Logged/Keyed?api_key=REDACTED HTTP/1.1" 200`) {
+		t.Errorf("got %q", accessLog.String())
+	}
+
+	u, _ := url.Parse("http://host/Sign?a=1&b%5F=2&c&expires=3&signature=x")
+	if got := redactedURI(u, map[string]bool{"b_": true, "signature": true}); got != "/Sign?a=1&b%5F=REDACTED&c&expires=3&signature=REDACTED" {
+		t.Errorf("got %s", got)
+	}
+}
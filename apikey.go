@@ -0,0 +1,94 @@
+package httpize
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// QueryAuthenticator is an Authenticator that can read credentials from a
+// query parameter, the parameter is not treated as an unknown argument.
+type QueryAuthenticator interface {
+	Authenticator
+	QueryParam() string
+}
+
+// APIKey is a key accepted by APIKeyAuth.
+type APIKey struct {
+	Key string
+	// ID of the key that isn't secret, eg for logs
+	ID string
+	// Principal of requests with the key, if nil a User with ID as its ID
+	Principal Principal
+	// Names of methods the key can be used for, any if nil
+	Methods []string
+}
+
+// APIKeyAuth is an Authenticator for API keys given in a header or query
+// parameter.
+type APIKeyAuth struct {
+	// Header with the key, if "" X-API-Key is used
+	Header string
+	// Query parameter with the key, if "" keys can only be given in Header
+	Param string
+	// Returns the APIKey for key, or nil if key is not valid. APIKeys can be
+	// used to make a Lookup for a fixed set of keys.
+	Lookup func(key string) (*APIKey, error)
+}
+
+func (a *APIKeyAuth) QueryParam() string {
+	return a.Param
+}
+
+// Authenticate returns the Principal of the key in the request. A request for
+// a method not allowed for the key gets a 403 error.
+func (a *APIKeyAuth) Authenticate(req *http.Request) (Principal, error) {
+	header := a.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+	key := req.Header.Get(header)
+	if key == "" && a.Param != "" {
+		key = req.URL.Query().Get(a.Param)
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	k, err := a.Lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return nil, Non500Error{401, "invalid API key", ""}
+	}
+	if k.Methods != nil {
+		pathParts := strings.Split(req.URL.Path, "/")
+		methodName := pathParts[len(pathParts)-1]
+		allowed := false
+		for _, m := range k.Methods {
+			allowed = allowed || m == methodName
+		}
+		if !allowed {
+			return nil, Non500Error{403, "API key not allowed for " + methodName, ""}
+		}
+	}
+	if k.Principal == nil {
+		return User{ID: k.ID}, nil
+	}
+	return k.Principal, nil
+}
+
+// APIKeys returns a func that can be used as APIKeyAuth.Lookup for keys. Keys
+// are compared in constant time.
+func APIKeys(keys ...APIKey) func(string) (*APIKey, error) {
+	return func(key string) (*APIKey, error) {
+		var found *APIKey
+		for i := range keys {
+			if subtle.ConstantTimeCompare([]byte(keys[i].Key), []byte(key)) == 1 {
+				found = &keys[i]
+			}
+		}
+		return found, nil
+	}
+}
@@ -0,0 +1,86 @@
+package httpize
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var apiKeyOptions = &Options{
+	Authenticator: &APIKeyAuth{
+		Param: "api_key",
+		Lookup: APIKeys(
+			APIKey{Key: "k1", Principal: User{ID: "service1"}},
+			APIKey{Key: "k2", Principal: User{ID: "service2"}, Methods: []string{"Report"}},
+			APIKey{Key: "k4", ID: "service4"},
+		),
+	},
+	RequireAuth: true,
+	Settings:    NewSettings().WithCache(60),
+}
+
+var _ = HandleWithOptions("/Keyed/Report", CallerFunc(NilSettings), apiKeyOptions)
+var _ = HandleWithOptions("/Keyed/Delete", CallerFunc(NilSettings), apiKeyOptions)
+
+func TestAPIKeyAuth(t *testing.T) {
+	for _, c := range []struct {
+		url, header string
+		code        int
+	}{
+		{"http://host/Keyed/Delete", "k1", 200},
+		{"http://host/Keyed/Delete?api_key=k1", "", 200},
+		{"http://host/Keyed/Report", "k2", 200},
+		{"http://host/Keyed/Delete", "k2", 403},
+		{"http://host/Keyed/Delete", "k3", 401},
+		{"http://host/Keyed/Delete", "k4", 200},
+		{"http://host/Keyed/Delete", "", 401},
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", c.url, nil)
+		if c.header != "" {
+			request.Header.Set("X-API-Key", c.header)
+		}
+		GetHandlerForPattern(request.URL.Path).ServeHTTP(recorder, request)
+		checkCode(t, recorder, c.code)
+		if c.code == 200 {
+			if recorder.Header().Get("Expires") != "" || recorder.Header().Get("Cache-Control") != "private, max-age=60" {
+				t.Fatalf("%s: cached publicly: %v", c.url, recorder.Header())
+			}
+		}
+	}
+}
+
+func TestAPIKeyWithoutPrincipal(t *testing.T) {
+	request, _ := http.NewRequest("GET", "http://host/Keyed/Delete", nil)
+	request.Header.Set("X-API-Key", "k4")
+	p, err := apiKeyOptions.Authenticator.Authenticate(request)
+	if err != nil || p == nil || p.Name() != "service4" {
+		t.Fatalf("got %v %v", p, err)
+	}
+}
+
+var lookupLogger = new(testLogger)
+
+var _ = HandleWithOptions("/KeyStore/Report", CallerFunc(NilSettings), &Options{
+	Authenticator: &APIKeyAuth{Lookup: func(string) (*APIKey, error) {
+		return nil, errors.New("key store: connection refused")
+	}},
+	Logger: lookupLogger,
+})
+
+func TestAPIKeyLookupError(t *testing.T) {
+	lookupLogger.errors = nil
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/KeyStore/Report", nil)
+	request.Header.Set("X-API-Key", "k1")
+	GetHandlerForPattern("/KeyStore/Report").ServeHTTP(recorder, request)
+	checkCode(t, recorder, 401)
+	if strings.Contains(recorder.Body.String(), "key store") {
+		t.Fatalf("error sent to client: %s", recorder.Body.String())
+	}
+	if len(lookupLogger.errors) != 1 || !strings.Contains(lookupLogger.errors[0], "connection refused") {
+		t.Fatalf("error not logged: %v", lookupLogger.errors)
+	}
+}
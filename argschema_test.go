@@ -0,0 +1,67 @@
+package httpize
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Color is an Arg added with a schema.
+type Color string
+
+func (c Color) Check() error {
+	switch c {
+	case "red", "green", "blue":
+		return nil
+	}
+	return Non500Error{400, "unknown color", ""}
+}
+
+var _ = AddTypeSchema("Color", func(s string) Arg { return Color(s) }, map[string]interface{}{"enum": []string{"red", "green", "blue"}})
+
+// Counted counts how many have been made
+type Counted string
+
+func (c Counted) Check() error {
+	return nil
+}
+
+var countedMade int
+
+var _ = AddType("Counted", func(s string) Arg {
+	countedMade++
+	return Counted(s)
+})
+
+var _ = Handle("/Paint?color Color=red", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return strings.NewReader(string(args["color"].(Color))), nil, nil
+}))
+
+func TestArgSchema(t *testing.T) {
+	want := map[string]interface{}{"enum": []string{"red", "green", "blue"}}
+	for _, m := range Methods() {
+		if m.Path == "/Paint" && !reflect.DeepEqual(m.Args[0].Schema, want) {
+			t.Errorf("got %v", m.Args[0].Schema)
+		}
+	}
+
+	params := OpenAPI(OpenAPIInfo{})["paths"].(map[string]interface{})["/Paint"].(map[string]interface{})["get"].(map[string]interface{})["parameters"].([]interface{})
+	schema := params[0].(map[string]interface{})["schema"].(map[string]interface{})
+	if !reflect.DeepEqual(schema["enum"], want["enum"]) || schema["type"] != "string" || schema["default"] != "red" || schema["x-httpize-type"] != "Color" {
+		t.Errorf("got %v", schema)
+	}
+}
+
+// Counted Args made by adding a handler
+var countedByHandle = func() int {
+	made := countedMade
+	Handle("/CountedEcho?c Counted", CallerFunc(NilSettings))
+	return countedMade - made
+}()
+
+func TestAddTypeNotMade(t *testing.T) {
+	if countedByHandle != 0 {
+		t.Fatal("Arg made when handler added")
+	}
+}
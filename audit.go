@@ -0,0 +1,89 @@
+package httpize
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// AuditEntry records a call of a method.
+type AuditEntry struct {
+	Time   time.Time
+	Method string
+	// Name of the Principal the request was authenticated as, or ""
+	Principal string
+	// Query parameter arguments formatted with fmt.Sprint, arguments with
+	// ArgDef.Redact set have the value "[REDACTED]"
+	Args     map[string]string
+	Status   int
+	Duration time.Duration
+}
+
+// AuditSink is given an AuditEntry for each call of a method, once the
+// response has been written. Set it in Options.Audit.
+type AuditSink interface {
+	Audit(e AuditEntry)
+}
+
+// AuditFunc is an AuditSink calling itself.
+type AuditFunc func(e AuditEntry)
+
+func (f AuditFunc) Audit(e AuditEntry) {
+	f(e)
+}
+
+// auditArgs returns args formatted for an AuditEntry or Span. Values are
+// redacted if redactAll is true.
+func (b argBuilderSlice) auditArgs(args map[string]Arg, redactAll bool) map[string]string {
+	m := make(map[string]string)
+	for i := range b {
+		a, ok := args[b[i].key]
+		if !ok || !b[i].query() {
+			continue
+		}
+		if b[i].redact || redactAll {
+			m[b[i].key] = "[REDACTED]"
+		} else {
+			m[b[i].key] = fmt.Sprint(a)
+		}
+	}
+	return m
+}
+
+// statusWriter keeps the status code and number of bytes written.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+	size int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// ReadFrom is so sendfile is still used for Settings.Sendfile.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := io.Copy(w.ResponseWriter, r)
+	w.size += n
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,156 @@
+package httpize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Principal is an authenticated user or client.
+type Principal interface {
+	// Name identifying the principal, eg a user name
+	Name() string
+	// HasRole reports whether the principal has a role or scope
+	HasRole(role string) bool
+}
+
+// User is a Principal with a name and list of roles.
+type User struct {
+	ID    string
+	Roles []string
+}
+
+func (u User) Name() string {
+	return u.ID
+}
+
+func (u User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator authenticates requests, it is set in Options.Authenticator.
+// Authenticate() returns a nil Principal and nil error if the request has no
+// credentials. An error is responded to with a 401 error, unless it is an
+// HTTPError.
+type Authenticator interface {
+	Authenticate(req *http.Request) (Principal, error)
+}
+
+// Challenger can be implemented by an Authenticator to give the
+// WWW-Authenticate header of 401 error responses.
+type Challenger interface {
+	Challenge() string
+}
+
+// WithRoles returns a Caller for c to be given to Handle, requests to the
+// handler must be authenticated by Options.Authenticator as a Principal with
+// all of roles. Unauthenticated requests get a 401 error and ones without the
+// roles a 403 error. Optional interfaces c implements are still used by the
+// handler.
+func WithRoles(c Caller, roles ...string) Caller {
+	return rolesCaller{c, roles}
+}
+
+type rolesCaller struct {
+	Caller
+	roles []string
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the Principal of the request the context is
+// from, as given to a ContextCaller, or nil if the request was not
+// authenticated.
+func PrincipalFromContext(ctx context.Context) Principal {
+	p, _ := ctx.Value(principalKey{}).(Principal)
+	return p
+}
+
+// PrincipalOf returns the Principal req was authenticated as, or nil.
+func PrincipalOf(req *http.Request) Principal {
+	return PrincipalFromContext(req.Context())
+}
+
+// authenticate authenticates req if Options.Authenticator is set and checks
+// the handler roles. Returns req with the Principal in its context. Errors
+// from the Authenticator that aren't HTTPErrors are logged, the client just
+// gets a 401 error.
+func (h *handler) authenticate(req *http.Request, start time.Time) (*http.Request, error) {
+	a := h.options.Authenticator
+	if a == nil {
+		if len(h.roles) > 0 || h.options.RequireAuth {
+			return req, Non500Error{401, "authentication required", ""}
+		}
+		return req, nil
+	}
+
+	p, err := a.Authenticate(req)
+	if err != nil {
+		var httpErr HTTPError
+		if !errors.As(err, &httpErr) {
+			h.logError(fmt.Errorf("authentication failed: %w", err), req, start, 401)
+			err = unauthorized(a, "authentication failed")
+		}
+		return req, err
+	}
+	if p == nil {
+		if len(h.roles) > 0 || h.options.RequireAuth {
+			return req, unauthorized(a, "authentication required")
+		}
+		return req, nil
+	}
+	for _, r := range h.roles {
+		if !p.HasRole(r) {
+			return req, Non500Error{403, "role " + r + " required", ""}
+		}
+	}
+	return req.WithContext(context.WithValue(req.Context(), principalKey{}, p)), nil
+}
+
+// unauthorized returns a 401 error, with WWW-Authenticate if a is a
+// Challenger.
+func unauthorized(a Authenticator, msg string) error {
+	c, ok := a.(Challenger)
+	if !ok {
+		return Non500Error{401, msg, ""}
+	}
+	return ResponseError{Code: 401, Message: msg, Header: http.Header{"Www-Authenticate": {c.Challenge()}}}
+}
+
+// BasicAuth is an Authenticator for HTTP Basic authentication. The Principal
+// is a User with the user name as ID.
+type BasicAuth struct {
+	// Realm given in the WWW-Authenticate challenge
+	Realm string
+	// Reports whether password is correct for user
+	Verify func(user, password string) bool
+	// Returns the roles of user, can be nil
+	Roles func(user string) []string
+}
+
+func (a *BasicAuth) Challenge() string {
+	return `Basic realm="` + strings.ReplaceAll(a.Realm, `"`, `\"`) + `", charset="UTF-8"`
+}
+
+func (a *BasicAuth) Authenticate(req *http.Request) (Principal, error) {
+	if req.Header.Get("Authorization") == "" {
+		return nil, nil
+	}
+	user, password, ok := req.BasicAuth()
+	if !ok || !a.Verify(user, password) {
+		return nil, errors.New("invalid user name or password")
+	}
+	p := User{ID: user}
+	if a.Roles != nil {
+		p.Roles = a.Roles(user)
+	}
+	return p, nil
+}
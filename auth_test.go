@@ -0,0 +1,158 @@
+package httpize
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// authenticates X-User header values alice, an admin, and bob
+type testAuthenticator struct{}
+
+func (testAuthenticator) Authenticate(req *http.Request) (Principal, error) {
+	switch req.Header.Get("X-User") {
+	case "":
+		return nil, nil
+	case "alice":
+		return User{"alice", []string{"admin"}}, nil
+	case "bob":
+		return User{ID: "bob"}, nil
+	}
+	return nil, errors.New("unknown user")
+}
+
+var authOptions = &Options{Authenticator: testAuthenticator{}}
+
+var _ = HandleWithOptions("/Auth/Admin", WithRoles(CallerFunc(NilSettings), "admin"), authOptions)
+var _ = HandleWithOptions("/Auth/Public", CallerFunc(NilSettings), authOptions)
+
+func TestAuthenticator(t *testing.T) {
+	for _, c := range []struct {
+		path, user string
+		code       int
+	}{
+		{"/Auth/Admin", "alice", 200},
+		{"/Auth/Admin", "bob", 403},
+		{"/Auth/Admin", "", 401},
+		{"/Auth/Admin", "eve", 401},
+		{"/Auth/Public", "", 200},
+		{"/Auth/Public", "bob", 200},
+	} {
+		h := GetHandlerForPattern(c.path)
+
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host"+c.path, nil)
+		request.Header.Set("X-User", c.user)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, c.code)
+	}
+}
+
+var _ = HandleWithOptions("/Basic/Admin", WithRoles(CallerFunc(NilSettings), "admin"), &Options{
+	Authenticator: &BasicAuth{
+		Realm: "test",
+		Verify: func(user, password string) bool {
+			return user == "alice" && password == "secret"
+		},
+		Roles: func(user string) []string { return []string{"admin"} },
+	},
+})
+
+func TestBasicAuth(t *testing.T) {
+	h := GetHandlerForPattern("/Basic/Admin")
+
+	for _, password := range []string{"", "wrong", "secret"} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/Basic/Admin", nil)
+		if password != "" {
+			request.SetBasicAuth("alice", password)
+		}
+		h.ServeHTTP(recorder, request)
+		if password == "secret" {
+			checkCode(t, recorder, 200)
+			continue
+		}
+		checkCode(t, recorder, 401)
+		if v := recorder.Header().Get("WWW-Authenticate"); v != `Basic realm="test", charset="UTF-8"` {
+			t.Fatalf("WWW-Authenticate %s", v)
+		}
+	}
+}
+
+func Whoami(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	p, ok := args["user"].(PrincipalArg)
+	if !ok {
+		return strings.NewReader("anonymous"), nil, nil
+	}
+	return strings.NewReader(p.Name()), nil, nil
+}
+
+var _ = HandleWithOptions("/Auth/Whoami?user Principal", CallerFunc(Whoami), authOptions)
+var _ = HandleWithOptions("/Auth/MaybeWhoami?user Principal=", CallerFunc(Whoami), authOptions)
+
+func TestPrincipalArg(t *testing.T) {
+	for _, c := range []struct {
+		pattern, user string
+		code          int
+		body          string
+	}{
+		{"/Auth/Whoami?user Principal", "bob", 200, "bob"},
+		{"/Auth/Whoami?user Principal", "", 401, ""},
+		{"/Auth/MaybeWhoami?user Principal=", "", 200, "anonymous"},
+		{"/Auth/MaybeWhoami?user Principal=", "alice", 200, "alice"},
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host"+strings.Split(c.pattern, "?")[0], nil)
+		request.Header.Set("X-User", c.user)
+		GetHandlerForPattern(c.pattern).ServeHTTP(recorder, request)
+		checkCode(t, recorder, c.code)
+		if c.code == 200 && recorder.Body.String() != c.body {
+			t.Fatalf("got %s", recorder.Body.String())
+		}
+	}
+}
+
+type Audited string
+
+func (a Audited) Check() error {
+	return nil
+}
+
+var _ = AddType("Audited", func(s string) Arg { return Audited(s) })
+
+var audited []AuditEntry
+
+var _ = HandleArgs("/Auth/Transfer", CallerFunc(NilSettings), []ArgDef{
+	{Key: "to", Type: "Principal"},
+	{Key: "account", Type: "Audited", Required: true},
+	{Key: "pin", Type: "Audited", Required: true, Redact: true},
+}, &Options{
+	Authenticator: testAuthenticator{},
+	Audit:         AuditFunc(func(e AuditEntry) { audited = append(audited, e) }),
+})
+
+func TestAudit(t *testing.T) {
+	h := GetHandlerForPattern("/Auth/Transfer")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Auth/Transfer?account=12&pin=1234", nil)
+	request.Header.Set("X-User", "alice")
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Auth/Transfer?account=12", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 400)
+
+	if len(audited) != 1 {
+		t.Fatalf("%d calls audited", len(audited))
+	}
+	e := audited[0]
+	if e.Method != "Transfer" || e.Principal != "alice" || e.Status != 200 || e.Args["account"] != "12" || e.Args["pin"] != "[REDACTED]" || len(e.Args) != 2 {
+		t.Fatalf("audited %+v", e)
+	}
+}
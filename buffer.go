@@ -0,0 +1,55 @@
+package httpize
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer is the largest capacity of buffers put back in bufferPool,
+// so that a few large responses don't keep their memory.
+const maxPooledBuffer = 64 << 10
+
+var bufferPool sync.Pool
+
+// buffers are the buffers got for a request, they are put back in bufferPool
+// by release when the request is done.
+type buffers []*bytes.Buffer
+
+// get returns an empty buffer from bufferPool.
+func (b *buffers) get() *bytes.Buffer {
+	buf, ok := bufferPool.Get().(*bytes.Buffer)
+	if !ok {
+		buf = new(bytes.Buffer)
+	}
+	*b = append(*b, buf)
+	return buf
+}
+
+func (b *buffers) release() {
+	for _, buf := range *b {
+		if buf.Cap() <= maxPooledBuffer {
+			buf.Reset()
+			bufferPool.Put(buf)
+		}
+	}
+	*b = nil
+}
+
+var bufioPool sync.Pool
+
+// bufioWriter returns a bufio.Writer writing to w from a pool, it is put back
+// with putBufioWriter.
+func bufioWriter(w io.Writer) *bufio.Writer {
+	if bw, ok := bufioPool.Get().(*bufio.Writer); ok {
+		bw.Reset(w)
+		return bw
+	}
+	return bufio.NewWriter(w)
+}
+
+func putBufioWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	bufioPool.Put(bw)
+}
@@ -0,0 +1,37 @@
+package httpize
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuffers(t *testing.T) {
+	var bufs buffers
+	small := bufs.get()
+	small.WriteString("small")
+	large := bufs.get()
+	large.Write(make([]byte, maxPooledBuffer+1))
+	if len(bufs) != 2 {
+		t.Fatalf("got %d buffers", len(bufs))
+	}
+	bufs.release()
+	if bufs != nil {
+		t.Fatal("buffers not cleared")
+	}
+	if small.Len() != 0 {
+		t.Fatal("pooled buffer not reset")
+	}
+	if large.Len() == 0 {
+		t.Fatal("large buffer put back in pool")
+	}
+
+	bw := bufioWriter(new(bytes.Buffer))
+	putBufioWriter(bw)
+	var out bytes.Buffer
+	bw = bufioWriter(&out)
+	bw.WriteString("x")
+	bw.Flush()
+	if out.String() != "x" {
+		t.Fatalf("got %q", out.String())
+	}
+}
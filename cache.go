@@ -0,0 +1,240 @@
+package httpize
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache stores responses to GET requests, set it in
+// Options.ResponseCache. Responses are stored for the seconds given by
+// Settings.Cache and requests for the same method with the same arguments get
+// the stored response rather than the method being called again.
+//
+// Keys start with the method name and query arguments as given to
+// Options.Invalidate, followed by the parts of the request the response
+// can vary by.
+type ResponseCache interface {
+	Get(key string) (*StoredResponse, bool)
+	Put(key string, r *StoredResponse, ttl time.Duration)
+	// Delete removes the responses with keys starting with prefix.
+	Delete(prefix string)
+}
+
+// MemoryResponseCache is a ResponseCache keeping responses in memory. Create
+// with NewMemoryResponseCache.
+type MemoryResponseCache struct {
+	mu        sync.Mutex
+	responses map[string]storedEntry
+}
+
+func NewMemoryResponseCache() *MemoryResponseCache {
+	return &MemoryResponseCache{responses: make(map[string]storedEntry)}
+}
+
+func (c *MemoryResponseCache) Get(key string) (*StoredResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.responses[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.r, true
+}
+
+func (c *MemoryResponseCache) Put(key string, r *StoredResponse, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, e := range c.responses {
+		if now.After(e.expires) {
+			delete(c.responses, k)
+		}
+	}
+	c.responses[key] = storedEntry{r, now.Add(ttl)}
+}
+
+func (c *MemoryResponseCache) Delete(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.responses {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.responses, k)
+		}
+	}
+}
+
+// Invalidate removes the responses for the method at path, eg "/api/Report",
+// called with args from o.ResponseCache, or for all args if args is nil.
+func (o *Options) Invalidate(path string, args url.Values) {
+	if o.ResponseCache == nil {
+		return
+	}
+	if args == nil {
+		o.ResponseCache.Delete(path + "?")
+		return
+	}
+	o.ResponseCache.Delete(path + "?" + args.Encode() + "#")
+}
+
+// cacheKey returns the ResponseCache key for req, false if the response can't
+// be cached or shared because it may be per user.
+func (h *handler) cacheKey(req *http.Request) (string, bool) {
+	if req.Method != "GET" || req.Header.Get("Authorization") != "" ||
+		PrincipalFromContext(req.Context()) != nil || h.options.CSRF != nil {
+		return "", false
+	}
+	for i := range h.argBuilders {
+		if h.argBuilders[i].principal || h.argBuilders[i].session {
+			return "", false
+		}
+	}
+	q, err := parseQuery(req.URL.RawQuery)
+	if err != nil {
+		return "", false
+	}
+	args, other := make(url.Values), make(url.Values)
+	for _, p := range q {
+		if h.queryArgs[p.key] {
+			args[p.key] = append(args[p.key], p.value)
+		} else {
+			other[p.key] = append(other[p.key], p.value)
+		}
+	}
+	return h.path + "?" + args.Encode() + "#" + other.Encode() +
+		"#" + req.Header.Get("Accept") + "#" + req.Header.Get("Accept-Encoding"), true
+}
+
+// shareResponse writes the response to req from Options.ResponseCache, or of
+// an identical request being handled if Options.Collapse is set, and returns
+// true. Otherwise it returns resp wrapped to record the response, and a func to
+// call once the response is written to share it. Responses that vary by
+// request headers not in the key aren't shared.
+func (h *handler) shareResponse(resp http.ResponseWriter, req *http.Request) (http.ResponseWriter, func(), bool) {
+	key, ok := h.cacheKey(req)
+	if !ok {
+		return resp, func() {}, false
+	}
+	if h.options.ResponseCache != nil {
+		if r, ok := h.options.ResponseCache.Get(key); ok {
+			writeStored(resp, r)
+			return resp, nil, true
+		}
+	}
+	var f *flight
+	if h.flights != nil {
+		var leader bool
+		f, leader = h.flights.join(key)
+		if !leader {
+			select {
+			case <-f.done:
+			case <-req.Context().Done():
+				return resp, nil, true
+			}
+			if f.r != nil {
+				writeStored(resp, f.r)
+				return resp, nil, true
+			}
+			// the call didn't write a response, so make it again
+			f = nil
+		}
+	}
+
+	rec := &recordingWriter{ResponseWriter: resp}
+	return rec, func() {
+		var r *StoredResponse
+		if rec.code != 0 && keyedVary(rec.Header()) {
+			r = &StoredResponse{StatusCode: rec.code, Header: rec.Header().Clone(), Body: rec.body.Bytes()}
+		}
+		if f != nil {
+			h.flights.finish(key, f, r)
+		}
+		if h.options.ResponseCache != nil && r != nil {
+			h.storeCached(key, r)
+		}
+	}, false
+}
+
+// keyedVary returns true if the Vary header of h only names request headers
+// that are part of the key returned by cacheKey.
+func keyedVary(h http.Header) bool {
+	for _, v := range h.Values("Vary") {
+		for _, k := range strings.Split(v, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if k != "" && k != "Accept" && k != "Accept-Encoding" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func writeStored(resp http.ResponseWriter, r *StoredResponse) {
+	for k, v := range r.Header {
+		resp.Header()[k] = v
+	}
+	resp.WriteHeader(r.StatusCode)
+	resp.Write(r.Body)
+}
+
+// storeCached puts r in Options.ResponseCache if it was successful and has a
+// Cache-Control max-age.
+func (h *handler) storeCached(key string, r *StoredResponse) {
+	if r.StatusCode != http.StatusOK || r.Header.Get("Set-Cookie") != "" {
+		return
+	}
+	var ttl time.Duration
+	for _, d := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		d = strings.TrimSpace(d)
+		if d == "private" || d == "no-store" || d == "no-cache" {
+			return
+		}
+		if v, ok := strings.CutPrefix(d, "max-age="); ok {
+			s, err := strconv.Atoi(v)
+			if err != nil {
+				return
+			}
+			ttl = time.Duration(s) * time.Second
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	h.options.ResponseCache.Put(key, r, ttl)
+}
+
+// flight is a request being handled that identical requests wait for.
+type flight struct {
+	done chan struct{}
+	// nil if no response was written
+	r *StoredResponse
+}
+
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// join returns the flight for key, true if there was none and the caller must
+// call finish once it has the response.
+func (g *flightGroup) join(key string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	return f, true
+}
+
+func (g *flightGroup) finish(key string, f *flight, r *StoredResponse) {
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	f.r = r
+	close(f.done)
+}
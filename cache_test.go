@@ -0,0 +1,167 @@
+package httpize
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Report string
+
+func (r Report) Check() error {
+	return nil
+}
+
+var _ = AddType("Report", func(s string) Arg { return Report(s) })
+
+var reportCalls int
+
+var cacheOptions = &Options{ResponseCache: NewMemoryResponseCache()}
+
+var _ = HandleWithOptions("/CachedReport?name Report", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	reportCalls++
+	s := new(Settings)
+	s.SetToDefault()
+	s.Cache = 60
+	return strings.NewReader(string(args["name"].(Report)) + " " + strconv.Itoa(reportCalls)), s, nil
+}), cacheOptions)
+
+func TestResponseCache(t *testing.T) {
+	reportCalls = 0
+	cacheOptions.Invalidate("/CachedReport", nil)
+	h := GetHandlerForPattern("/CachedReport?name Report")
+	get := func(url string, header ...string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", url, nil)
+		for i := 0; i < len(header); i += 2 {
+			request.Header.Set(header[i], header[i+1])
+		}
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 200)
+		return recorder
+	}
+
+	if b := get("http://host/CachedReport?name=a").Body.String(); b != "a 1" {
+		t.Fatalf("got %q", b)
+	}
+	r := get("http://host/CachedReport?name=a")
+	if b := r.Body.String(); b != "a 1" {
+		t.Fatalf("not cached, got %q", b)
+	}
+	if r.Header().Get("Cache-Control") == "" {
+		t.Fatal("headers not cached")
+	}
+	if b := get("http://host/CachedReport?name=b").Body.String(); b != "b 2" {
+		t.Fatalf("got %q", b)
+	}
+	if b := get("http://host/CachedReport?name=a", "Authorization", "Bearer x").Body.String(); b != "a 3" {
+		t.Fatalf("authorized request cached, got %q", b)
+	}
+
+	cacheOptions.Invalidate("/CachedReport", url.Values{"name": {"a"}})
+	if b := get("http://host/CachedReport?name=a").Body.String(); b != "a 4" {
+		t.Fatalf("not invalidated, got %q", b)
+	}
+	if b := get("http://host/CachedReport?name=b").Body.String(); b != "b 2" {
+		t.Fatalf("other args invalidated, got %q", b)
+	}
+	cacheOptions.Invalidate("/CachedReport", nil)
+	if b := get("http://host/CachedReport?name=b").Body.String(); b != "b 5" {
+		t.Fatalf("not invalidated, got %q", b)
+	}
+}
+
+var _ = HandleWithOptions("/Other/CachedReport?name Report", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	s := new(Settings)
+	s.SetToDefault()
+	s.Cache = 60
+	return strings.NewReader("other " + string(args["name"].(Report))), s, nil
+}), cacheOptions)
+
+func TestResponseCachePath(t *testing.T) {
+	cacheOptions.Invalidate("/CachedReport", nil)
+	for _, c := range []struct{ path, body string }{
+		{"/CachedReport", "a "},
+		{"/Other/CachedReport", "other a"},
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host"+c.path+"?name=a", nil)
+		GetHandlerForPattern(c.path+"?name Report").ServeHTTP(recorder, request)
+		if !strings.HasPrefix(recorder.Body.String(), c.body) {
+			t.Fatalf("%s: got %q", c.path, recorder.Body.String())
+		}
+	}
+}
+
+var varyCalls int
+
+var _ = HandleWithOptions("/Vary/CachedReport?name Report", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	varyCalls++
+	s := &Settings{Cache: 60, Vary: []string{"Accept-Language"}}
+	return strings.NewReader(string(args["name"].(Report)) + " " + strconv.Itoa(varyCalls)), s, nil
+}), cacheOptions)
+
+func TestResponseCacheVary(t *testing.T) {
+	varyCalls = 0
+	h := GetHandlerForPattern("/Vary/CachedReport?name Report")
+	for i, lang := range []string{"en", "fr"} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/Vary/CachedReport?name=a", nil)
+		request.Header.Set("Accept-Language", lang)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 200)
+		if b, want := recorder.Body.String(), "a "+strconv.Itoa(i+1); b != want {
+			t.Fatalf("%s: got %q, want %q", lang, b, want)
+		}
+	}
+}
+
+var collapseCalls int
+var collapseStarted = make(chan bool)
+var collapseRelease = make(chan bool)
+
+var _ = HandleWithOptions("/Collapsed?name Report", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	collapseCalls++
+	collapseStarted <- true
+	<-collapseRelease
+	return strings.NewReader("done " + string(args["name"].(Report))), nil, nil
+}), &Options{Collapse: true})
+
+func TestCollapse(t *testing.T) {
+	collapseCalls = 0
+	h := GetHandlerForPattern("/Collapsed?name Report")
+	recorders := make([]*httptest.ResponseRecorder, 3)
+	finished := make(chan bool)
+	serve := func(i int) {
+		recorders[i] = httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/Collapsed?name=a", nil)
+		h.ServeHTTP(recorders[i], request)
+		finished <- true
+	}
+
+	go serve(0)
+	<-collapseStarted
+	go serve(1)
+	go serve(2)
+	// let the other requests join the first
+	time.Sleep(50 * time.Millisecond)
+	collapseRelease <- true
+	for range recorders {
+		<-finished
+	}
+
+	if collapseCalls != 1 {
+		t.Fatalf("method called %d times", collapseCalls)
+	}
+	for _, r := range recorders {
+		checkCode(t, r, 200)
+		if r.Body.String() != "done a" {
+			t.Fatalf("got %q", r.Body.String())
+		}
+	}
+}
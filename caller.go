@@ -1,7 +1,13 @@
 package httpize
 
 import (
+	"context"
+	"errors"
 	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 )
 
 // Caller interface must be implemented by values that are to be used as handlers. 
@@ -10,17 +16,109 @@ type Caller interface {
 	// map[string]Arg is passed. The keys and underlying types of its values are the same 
 	// as specified by the handler pattern the Caller was passed to. Arg.Check() is
 	// called on each Arg. Return io.WriterTo will be used to write the HTTP 
-	// response body. Return *Settings is used to set HTTP options. If nil
+	// response body, it can only be nil if Settings.StatusCode is 204.
+	// Return *Settings is used to set HTTP options. If nil the
+	// Settings given with WithSettings, Options.Settings of the handler or
 	// defaults as per Settings.SetToDefault() will be used. Return error if not
-	// nil causes HTTP 500 error responses, unless of is of type Non500Error in which
-	// the error code can be specified.
+	// nil causes HTTP 500 error responses, unless of is an HTTPError, eg Non500Error, in which
+	// the error code can be specified. If the io.WriterTo is also an io.Closer
+	// it is closed once the response has been written, also when an error is
+	// returned.
 	Call(map[string]Arg) (io.WriterTo, *Settings, error)
 }
 
+// WithSettings returns a Caller for c to be given to Handle, the handler will
+// use s when c returns nil Settings. Optional interfaces c implements, like
+// CallValidator, are still used by the handler.
+func WithSettings(c Caller, s *Settings) Caller {
+	return settingsCaller{c, s}
+}
+
+type settingsCaller struct {
+	Caller
+	settings *Settings
+}
+
+// Reader returns an io.WriterTo that copies from r, so that a Caller can
+// return any io.Reader. If r is an io.ReadCloser the returned value is also an
+// io.Closer and r will be closed after the response is written. Returns nil if
+// r is nil.
+func Reader(r io.Reader) io.WriterTo {
+	if r == nil {
+		return nil
+	}
+	if rc, ok := r.(io.ReadCloser); ok {
+		return readCloserTo{readerTo{rc}, rc}
+	}
+	return readerTo{r}
+}
+
+type readerTo struct {
+	r io.Reader
+}
+
+func (r readerTo) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, r.r)
+}
+
+type readCloserTo struct {
+	readerTo
+	io.Closer
+}
+
+// fileOf returns the *os.File w is or was made from by Reader, or nil.
+func fileOf(w io.WriterTo) *os.File {
+	switch v := w.(type) {
+	case *os.File:
+		return v
+	case readerTo:
+		f, _ := v.r.(*os.File)
+		return f
+	case readCloserTo:
+		f, _ := v.r.(*os.File)
+		return f
+	}
+	return nil
+}
+
+// ContextCaller can optionally be implemented by a Caller to be passed a
+// context when called. CallContext() is then called instead of Call(). The
+// context is the request's context, it is done when the client goes away or
+// Options.Timeout has passed.
+type ContextCaller interface {
+	CallContext(ctx context.Context, args map[string]Arg) (io.WriterTo, *Settings, error)
+}
+
+// CallValidator can optionally be implemented by a Caller to check the
+// arguments of a call as a whole. ValidateCall() is called after Check() has
+// been called on each Arg and before Call(). methodName is the name part of the
+// handler pattern. If it returns an error the call is not made, errors are
+// handled the same way as errors returned by Call(), so a Non500Error can be
+// used to respond with a 4xx error code.
+type CallValidator interface {
+	ValidateCall(methodName string, args map[string]Arg) error
+}
+
+// BeforeCaller can be implemented by a Caller to do setup, authorization or
+// accounting before each call. BeforeCall() is called after ValidateCall(),
+// with the method name, arguments and request. An error returned is handled
+// the same way as errors returned by Call() and the call is not made.
+type BeforeCaller interface {
+	BeforeCall(methodName string, args map[string]Arg, req *http.Request) error
+}
+
+// AfterCaller can be implemented by a Caller to be told about each call once
+// it returns. AfterCall() is passed the method name, how long the call took
+// and the error returned, which is a 504 Non500Error if Options.Timeout was
+// exceeded, or describes the panic if the call panicked.
+type AfterCaller interface {
+	AfterCall(methodName string, d time.Duration, err error)
+}
+
 // Arg.Check() is called on all arguments before calling an Caller.Call, 
-// if it returns an error the call is not made and causes HTTP 500 error 
-// response, unless of the error is of type Non500Error. In which the error code 
-// can be specified.
+// if it returns an error the call is not made and causes HTTP 400 error 
+// response naming the argument, unless of the error is an HTTPError.
+// In which the error code can be specified.
 type Arg interface {
 	Check() error
 }
@@ -29,24 +127,175 @@ type argBuilderSlice []argBuilder
 
 type argBuilder struct {
 	key        string
+	typ        string // type name as in the handler pattern
 	createFunc func(string) Arg
+	// given with AddTypeSchema
+	schema map[string]interface{}
+	// argument is the request body rather than a query parameter
+	body bool
+	// argument is the Principal of the request rather than a query parameter
+	principal bool
+	// argument is the Session of the request rather than a query parameter
+	session bool
+	// argument must be given, otherwise def is used if not ""
+	required bool
+	def      string
+	// value not shown in AuditEntry
+	redact bool
+}
+
+// query reports whether the argument is from a query parameter.
+func (b *argBuilder) query() bool {
+	return !b.body && !b.principal && !b.session
+}
+
+// Body is the type of arguments declared with type Body in a handler pattern.
+// Reader reads the request body, it is limited to Options.MaxBodySize bytes
+// if that is set.
+type Body struct {
+	io.Reader
+}
+
+func (b Body) Check() error {
+	return nil
+}
+
+// missing returns the key of a required argument not in args, or "".
+func (b argBuilderSlice) missing(args map[string]Arg) string {
+	for i := range b {
+		if _, ok := args[b[i].key]; !ok && b[i].required && b[i].query() {
+			return b[i].key
+		}
+	}
+	return ""
+}
+
+func (b argBuilderSlice) buildBodyArgs(args map[string]Arg, r io.Reader) {
+	for i := range b {
+		if b[i].body {
+			args[b[i].key] = Body{r}
+		}
+	}
 }
 
-func (b argBuilderSlice) buildArgs(args map[string]Arg, f func(s string) (string, bool)) (int, error) {
-	paramCount := len(b)
+// buildPrincipalArgs adds p to args as a PrincipalArg for arguments of type
+// Principal. If p is nil a required argument causes an error.
+func (b argBuilderSlice) buildPrincipalArgs(args map[string]Arg, p Principal) error {
+	for i := range b {
+		if !b[i].principal {
+			continue
+		}
+		if p == nil && b[i].required {
+			return Non500Error{401, "authentication required", ""}
+		} else if p != nil {
+			args[b[i].key] = PrincipalArg{p}
+		}
+	}
+	return nil
+}
 
-	found := 0
-	for i := 0; i < paramCount; i++ {
-		if v, ok := f(b[i].key); ok {
-			arg := b[i].createFunc(v)
-			err := arg.Check()
+// buildSessionArgs adds a Session with the values loaded from store to args for
+// arguments of type Session. Returns the Session or nil if there are no such
+// arguments.
+func (b argBuilderSlice) buildSessionArgs(args map[string]Arg, store SessionStore, req *http.Request) (*Session, error) {
+	var s *Session
+	for i := range b {
+		if !b[i].session {
+			continue
+		}
+		if s == nil {
+			if store == nil {
+				return nil, errors.New("httpize: Session argument without Options.Sessions")
+			}
+			values, err := store.Load(req)
 			if err != nil {
-				return found, err
+				return nil, err
 			}
-			args[b[i].key] = arg
-			found++
+			s = &Session{values: values}
+		}
+		args[b[i].key] = s
+	}
+	return s, nil
+}
+
+// PrincipalArg is the type of arguments declared with type Principal in a
+// handler pattern. It holds the Principal the request was authenticated as by
+// Options.Authenticator. If the argument is required unauthenticated requests
+// get a 401 error, if it is optional it is left out of the map passed to Call.
+type PrincipalArg struct {
+	Principal
+}
+
+func (p PrincipalArg) Check() error {
+	return nil
+}
+
+// buildArgs creates and checks arguments using values returned by f. Check()
+// errors that are not HTTPError are returned as 400 errors naming the
+// argument. If all is true all arguments are checked, and Check() errors that
+// are not HTTPError and missing required arguments are returned together as
+// ArgErrors.
+func (b argBuilderSlice) buildArgs(args map[string]Arg, f func(s string) (string, bool), all bool) error {
+	var errs ArgErrors
+	for i := 0; i < len(b); i++ {
+		if !b[i].query() {
+			continue
+		}
+		v, ok := f(b[i].key)
+		if !ok && b[i].required && all {
+			errs = append(errs, ArgError{b[i].key, "missing"})
+			continue
+		} else if !ok && (b[i].required || b[i].def == "") {
+			continue
+		} else if !ok {
+			v = b[i].def
 		}
+		arg := b[i].createFunc(v)
+		err := arg.Check()
+		var httpErr HTTPError
+		if ok := errors.As(err, &httpErr); err != nil && !ok && all {
+			errs = append(errs, ArgError{b[i].key, err.Error()})
+			continue
+		} else if err != nil && !ok {
+			return Non500Error{400, "invalid parameter " + b[i].key + ": " + err.Error(), ""}
+		} else if err != nil {
+			return err
+		}
+		args[b[i].key] = arg
 	}
 
-	return found, nil
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// ArgError is an argument that is missing or failed Check().
+type ArgError struct {
+	Key     string `json:"parameter"`
+	Message string `json:"message"`
+}
+
+// ArgErrors is the error responded with when Options.AllArgErrors is set and
+// arguments are missing or fail Check(). It is an HTTPError with status 400.
+type ArgErrors []ArgError
+
+func (e ArgErrors) Error() string {
+	s := make([]string, len(e))
+	for i := range e {
+		s[i] = e[i].Key + ": " + e[i].Message
+	}
+	return "invalid parameters: " + strings.Join(s, "; ")
+}
+
+func (e ArgErrors) HTTPStatus() int {
+	return 400
+}
+
+func (e ArgErrors) HTTPHeader() http.Header {
+	return nil
+}
+
+func (e ArgErrors) HTTPBody() io.Reader {
+	return nil
 }
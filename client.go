@@ -0,0 +1,194 @@
+package httpize
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// WriteClient writes the Go source of package pkg with a Client type that has
+// a method for each method added, as returned by Methods. Client methods take
+// the query parameter arguments as strings, optional ones are left out of the
+// URL if "". Methods with a Body argument take an io.Reader and are POSTed,
+// others use GET. Methods responding with JSON decode the response into out,
+// others return the response body. Error responses are returned as a
+// *ClientError.
+func WriteClient(w io.Writer, pkg string) error {
+	var methods bytes.Buffer
+	var usesJSON bool
+	names := make(map[string]bool)
+	for _, m := range Methods() {
+		name := goName(m.Name, true)
+		for names[name] {
+			name += "_"
+		}
+		names[name] = true
+		if writeClientMethod(&methods, name, m) {
+			usesJSON = true
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by httpize.WriteClient. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\nimport (\n\t\"context\"\n", pkg)
+	if usesJSON {
+		buf.WriteString("\t\"encoding/json\"\n")
+	}
+	buf.WriteString(clientHeader)
+	buf.Write(methods.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// clientHeader follows the context and encoding/json imports
+const clientHeader = `	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Client calls methods at BaseURL, like "https://example.com".
+type Client struct {
+	BaseURL string
+	// http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// ClientError is the error returned for responses with a status code other
+// than 2xx.
+type ClientError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *ClientError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, body io.Reader) ([]byte, error) {
+	u := c.BaseURL + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &ClientError{resp.StatusCode, b}
+	}
+	return b, nil
+}
+`
+
+// writeClientMethod writes the Client method name for m, returns whether it
+// decodes JSON.
+func writeClientMethod(buf *bytes.Buffer, name string, m MethodInfo) bool {
+	var params []string
+	var body string
+	// names used in the method, and of the packages imported
+	vars := map[string]bool{"ctx": true, "out": true, "q": true, "b": true, "err": true, "c": true,
+		"context": true, "json": true, "fmt": true, "io": true, "http": true, "url": true}
+	args := make(map[string]string)
+	for _, a := range m.Args {
+		if a.Type == "Principal" || a.Type == "Session" {
+			continue
+		}
+		v := goName(a.Name, false)
+		for vars[v] || token.IsKeyword(v) {
+			v += "_"
+		}
+		vars[v] = true
+		args[a.Name] = v
+		if a.Type == "Body" {
+			body = v
+			params = append(params, v+" io.Reader")
+		} else {
+			params = append(params, v+" string")
+		}
+	}
+	isJSON := strings.HasPrefix(m.ContentType, "application/json")
+	if isJSON {
+		params = append(params, "out interface{}")
+	}
+
+	fmt.Fprintf(buf, "\n// %s calls %s.\n", name, m.Path)
+	fmt.Fprintf(buf, "func (c *Client) %s(ctx context.Context, %s) ", name, strings.Join(params, ", "))
+	if isJSON {
+		buf.WriteString("error {\n")
+	} else {
+		buf.WriteString("([]byte, error) {\n")
+	}
+	buf.WriteString("q := make(url.Values)\n")
+	for _, a := range m.Args {
+		v, ok := args[a.Name]
+		if !ok || a.Type == "Body" {
+			continue
+		}
+		if a.Required {
+			fmt.Fprintf(buf, "q.Set(%s, %s)\n", strconv.Quote(a.Name), v)
+		} else {
+			fmt.Fprintf(buf, "if %s != \"\" {\nq.Set(%s, %s)\n}\n", v, strconv.Quote(a.Name), v)
+		}
+	}
+	if body != "" {
+		fmt.Fprintf(buf, "b, err := c.do(ctx, \"POST\", %s, q, %s)\n", strconv.Quote(m.Path), body)
+	} else {
+		fmt.Fprintf(buf, "b, err := c.do(ctx, \"GET\", %s, q, nil)\n", strconv.Quote(m.Path))
+	}
+	if isJSON {
+		buf.WriteString("if err != nil {\nreturn err\n}\nreturn json.Unmarshal(b, out)\n}\n")
+	} else {
+		buf.WriteString("return b, err\n}\n")
+	}
+	return isJSON
+}
+
+// goName returns s as a Go identifier, exported if upper is true.
+func goName(s string, upper bool) string {
+	var b strings.Builder
+	next := upper
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			next = b.Len() > 0 || upper
+			continue
+		}
+		if b.Len() == 0 && unicode.IsDigit(r) {
+			b.WriteRune('X')
+		}
+		if next {
+			r = unicode.ToUpper(r)
+		} else if b.Len() == 0 {
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+		next = false
+	}
+	if b.Len() == 0 {
+		return "X"
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package httpize
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	gotypes "go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteClient(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteClient(&buf, "api"); err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "client.go", buf.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := gotypes.Config{Importer: importer.Default()}
+	if _, err := conf.Check("api", fset, []*ast.File{f}, nil); err != nil {
+		t.Fatalf("%s\n%s", err, buf.String())
+	}
+
+	for _, want := range []string{
+		"func (c *Client) Greet(ctx context.Context, thing string) ([]byte, error)",
+		"func (c *Client) Upload(ctx context.Context, name string, data io.Reader) ([]byte, error)",
+		`c.do(ctx, "POST", "/Upload", q, data)`,
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+var _ = Handle("/ClientNames?url Report&http Report&json Report&fmt Report&io Report&context Report", CallerFunc(NilSettings))
+
+func TestWriteClientBuilds(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found")
+	}
+	var buf bytes.Buffer
+	if err := WriteClient(&buf, "api"); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module api\n\ngo 1.21\n"), 0666)
+	os.WriteFile(filepath.Join(dir, "client.go"), buf.Bytes(), 0666)
+	cmd := exec.Command(goBin, "build", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=on", "GOTOOLCHAIN=local", "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s\n%s\n%s", err, out, buf.String())
+	}
+}
+
+func TestGoName(t *testing.T) {
+	for s, want := range map[string]string{"name": "Name", "first_name": "FirstName", "2fa": "X2fa", "": "X", "-": "X"} {
+		if got := goName(s, true); got != want {
+			t.Errorf("goName(%q) = %q, want %q", s, got, want)
+		}
+	}
+	if got := goName("First-name", false); got != "firstName" {
+		t.Errorf("got %q", got)
+	}
+}
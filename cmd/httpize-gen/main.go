@@ -0,0 +1,193 @@
+// Command httpize-gen generates code to add handlers for the methods of a
+// type, for use with go:generate. Methods that are exported and return
+// (io.WriterTo, *httpize.Settings, error) get a handler, with a pattern made
+// from the method name and parameter names and types. Parameter types must be
+// added with httpize.AddType under the name they are written as, or be
+// httpize.Body, httpize.PrincipalArg or *httpize.Session.
+//
+// Usage:
+//
+//	//go:generate httpize-gen -type Api
+//
+// writes api_httpize.go with a func HandleApi(path string, recv *Api, o
+// *httpize.Options) bool, that adds handlers for the methods of recv under
+// path and returns false if any couldn't be added. The generated Callers type assert arguments directly, without
+// reflection. Parameter types from other packages, other than the httpize
+// types, can't be in a pattern so are an error.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	typeName := flag.String("type", "", "type whose methods are handled")
+	dir := flag.String("dir", ".", "directory of the package with the type")
+	output := flag.String("o", "", "output file, default <type>_httpize.go")
+	flag.Parse()
+	if *typeName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, *dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && !strings.HasSuffix(fi.Name(), "_httpize.go")
+	}, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(pkgs) != 1 {
+		log.Fatalf("httpize-gen: %d packages in %s", len(pkgs), *dir)
+	}
+
+	var files []*ast.File
+	var pkgName string
+	for name, pkg := range pkgs {
+		pkgName = name
+		for _, f := range pkg.Files {
+			files = append(files, f)
+		}
+	}
+	src, err := generate(pkgName, *typeName, files)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *output == "" {
+		*output = filepath.Join(*dir, strings.ToLower(*typeName)+"_httpize.go")
+	}
+	if err := os.WriteFile(*output, src, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type param struct {
+	name, typ, pattern string
+}
+
+type method struct {
+	name   string
+	params []param
+}
+
+// patternTypes are the pattern types of httpize types that are not added with
+// AddType
+var patternTypes = map[string]string{
+	"httpize.Body":         "Body",
+	"httpize.PrincipalArg": "Principal",
+	"*httpize.Session":     "Session",
+}
+
+// methods returns the methods of typeName that can be handled, sorted by name.
+func methods(pkgName, typeName string, files []*ast.File) ([]method, error) {
+	var ms []method
+	for _, f := range files {
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || fd.Recv == nil || !fd.Name.IsExported() || recvName(fd.Recv.List[0].Type) != typeName {
+				continue
+			}
+			if !returnsResponse(fd.Type.Results) {
+				continue
+			}
+			m := method{name: fd.Name.Name}
+			for _, field := range fd.Type.Params.List {
+				typ := types.ExprString(field.Type)
+				pattern, ok := patternTypes[typ]
+				if !ok {
+					pattern = typ
+				}
+				if len(field.Names) == 0 {
+					return nil, fmt.Errorf("httpize-gen: %s.%s has unnamed parameters", typeName, m.name)
+				}
+				if strings.Contains(pattern, ".") {
+					return nil, fmt.Errorf("httpize-gen: %s.%s has a parameter of type %s, which can't be in a pattern, use a type of package %s added with httpize.AddType", typeName, m.name, typ, pkgName)
+				}
+				for _, n := range field.Names {
+					m.params = append(m.params, param{n.Name, typ, pattern})
+				}
+			}
+			ms = append(ms, m)
+		}
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].name < ms[j].name })
+	return ms, nil
+}
+
+func recvName(e ast.Expr) string {
+	if s, ok := e.(*ast.StarExpr); ok {
+		e = s.X
+	}
+	if i, ok := e.(*ast.Ident); ok {
+		return i.Name
+	}
+	return ""
+}
+
+func returnsResponse(results *ast.FieldList) bool {
+	if results == nil || results.NumFields() != 3 {
+		return false
+	}
+	var got []string
+	for _, f := range results.List {
+		for range max(1, len(f.Names)) {
+			got = append(got, types.ExprString(f.Type))
+		}
+	}
+	return strings.Join(got, ",") == "io.WriterTo,*httpize.Settings,error"
+}
+
+// generate returns the source of the file adding handlers for typeName.
+func generate(pkgName, typeName string, files []*ast.File) ([]byte, error) {
+	ms, err := methods(pkgName, typeName, files)
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 0 {
+		return nil, fmt.Errorf("httpize-gen: %s has no methods returning (io.WriterTo, *httpize.Settings, error)", typeName)
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "// Code generated by httpize-gen -type %s; DO NOT EDIT.\n\n", typeName)
+	fmt.Fprintf(buf, "package %s\n\n", pkgName)
+	fmt.Fprintf(buf, "import (\n\t\"io\"\n\n\t\"github.com/timob/httpize\"\n)\n\n")
+	caller := "httpize" + typeName + "Caller"
+	fmt.Fprintf(buf, "type %s func(map[string]httpize.Arg) (io.WriterTo, *httpize.Settings, error)\n\n", caller)
+	fmt.Fprintf(buf, "func (f %s) Call(args map[string]httpize.Arg) (io.WriterTo, *httpize.Settings, error) {\n\treturn f(args)\n}\n\n", caller)
+	fmt.Fprintf(buf, "// Handle%s adds handlers for the methods of recv under path, using Options o.\n", typeName)
+	fmt.Fprintf(buf, "func Handle%s(path string, recv *%s, o *httpize.Options) bool {\n\tok := true\n", typeName, typeName)
+	for _, m := range ms {
+		// arguments are a0, a1... so parameter names can't clash with
+		// other names
+		var pattern, call []string
+		for i, p := range m.params {
+			pattern = append(pattern, p.name+" "+p.pattern)
+			call = append(call, "a"+strconv.Itoa(i))
+		}
+		p := m.name
+		if len(pattern) > 0 {
+			p += "?" + strings.Join(pattern, "&")
+		}
+		fmt.Fprintf(buf, "\tok = httpize.HandleWithOptions(path+%q, %s(func(args map[string]httpize.Arg) (io.WriterTo, *httpize.Settings, error) {\n", "/"+p, caller)
+		for i, p := range m.params {
+			fmt.Fprintf(buf, "\t\t%s, _ := args[%q].(%s)\n", call[i], p.name, p.typ)
+		}
+		fmt.Fprintf(buf, "\t\treturn recv.%s(%s)\n\t}), o) && ok\n", m.name, strings.Join(call, ", "))
+	}
+	fmt.Fprintf(buf, "\treturn ok\n}\n")
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+const src = `package api
+
+type Api struct{}
+
+func (a *Api) Echo(name SafeString, data httpize.Body) (io.WriterTo, *httpize.Settings, error) {
+	return nil, nil, nil
+}
+
+func (a *Api) Ping() (io.WriterTo, *httpize.Settings, error) {
+	return nil, nil, nil
+}
+
+func (a *Api) helper() (io.WriterTo, *httpize.Settings, error) {
+	return nil, nil, nil
+}
+
+func (a *Api) Other() error {
+	return nil
+}
+`
+
+func TestGenerate(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "api.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := generate("api", "Api", []*ast.File{f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`func HandleApi(path string, recv *Api, o *httpize.Options) bool {`,
+		`ok := true`,
+		`ok = httpize.HandleWithOptions(path+"/Echo?name SafeString&data Body", httpizeApiCaller(func(`,
+		`a0, _ := args["name"].(SafeString)`,
+		`a1, _ := args["data"].(httpize.Body)`,
+		`return recv.Echo(a0, a1)`,
+		`ok = httpize.HandleWithOptions(path+"/Ping", httpizeApiCaller(func(`,
+		`}), o) && ok`,
+		`return ok`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("%s not in\n%s", want, out)
+		}
+	}
+	if strings.Contains(string(out), "helper") || strings.Contains(string(out), "Other") {
+		t.Fatalf("unhandled method in\n%s", out)
+	}
+}
+
+// parameters named like the variables of the generated code
+const clashSrc = `package api
+
+import (
+	"io"
+
+	"github.com/timob/httpize"
+)
+
+type Api struct{}
+
+type SafeString string
+
+func (s SafeString) Check() error { return nil }
+
+func (a *Api) Get(p SafeString, o SafeString, args SafeString, path SafeString, recv SafeString) (io.WriterTo, *httpize.Settings, error) {
+	return nil, nil, nil
+}
+`
+
+func TestGenerateCompiles(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "api.go", clashSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := generate("api", "Api", []*ast.File{f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := parser.ParseFile(fset, "api_httpize.go", out, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("api", fset, []*ast.File{f, gen}, nil); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+}
+
+func TestGenerateQualified(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "api.go", `package api
+
+type Api struct{}
+
+func (a *Api) At(t time.Time) (io.WriterTo, *httpize.Settings, error) {
+	return nil, nil, nil
+}
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generate("api", "Api", []*ast.File{f}); err == nil || !strings.Contains(err.Error(), "time.Time") {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+// Command httpize lists and calls the methods of an httpize service. The
+// service must have added a handler with httpize.HandleMethods at the base
+// URL.
+//
+// Usage:
+//
+//	httpize -url https://example.com/api list
+//	httpize -url https://example.com/api call -param thing=world Greet
+//	httpize -url https://example.com/api call -param name=file -body data.txt Upload
+//
+// The base URL can also be given in the HTTPIZE_URL environment variable. The
+// response body of a call is written to standard output. A -body of "-" is
+// read from standard input.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/timob/httpize"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("httpize: ")
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// params is a flag.Value collecting name=value flags.
+type params url.Values
+
+func (p params) String() string {
+	return url.Values(p).Encode()
+}
+
+func (p params) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return errors.New("param must be name=value")
+	}
+	url.Values(p).Add(k, v)
+	return nil
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("httpize", flag.ContinueOnError)
+	base := flags.String("url", os.Getenv("HTTPIZE_URL"), "base URL of the service")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *base == "" {
+		return errors.New("no -url or HTTPIZE_URL given")
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		return errors.New("no command given, list or call")
+	}
+
+	methods, err := getMethods(*base)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+		for _, m := range methods {
+			list(stdout, m)
+		}
+		return nil
+	case "call":
+		return call(*base, methods, args[1:], stdin, stdout)
+	}
+	return errors.New("unknown command " + args[0])
+}
+
+func getMethods(base string) ([]httpize.MethodInfo, error) {
+	resp, err := http.Get(base + "/_methods")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("getting methods: " + resp.Status)
+	}
+	var methods []httpize.MethodInfo
+	err = json.NewDecoder(resp.Body).Decode(&methods)
+	return methods, err
+}
+
+func list(w io.Writer, m httpize.MethodInfo) {
+	fmt.Fprintf(w, "%s\t%s", m.Name, m.Path)
+	for _, a := range m.Args {
+		switch {
+		case a.Default != "":
+			fmt.Fprintf(w, " [%s %s=%s]", a.Name, a.Type, a.Default)
+		case !a.Required:
+			fmt.Fprintf(w, " [%s %s]", a.Name, a.Type)
+		default:
+			fmt.Fprintf(w, " %s %s", a.Name, a.Type)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+func call(base string, methods []httpize.MethodInfo, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("call", flag.ContinueOnError)
+	p := make(params)
+	flags.Var(p, "param", "argument as name=value, can be repeated")
+	bodyFile := flags.String("body", "", "file to send as the request body, - for standard input")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("call needs one method name")
+	}
+	name := flags.Arg(0)
+
+	var m *httpize.MethodInfo
+	for i := range methods {
+		if methods[i].Name == name || methods[i].Path == name {
+			m = &methods[i]
+			break
+		}
+	}
+	if m == nil {
+		return errors.New("no method " + name)
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	// method paths are absolute
+	u.Path, u.RawQuery = m.Path, url.Values(p).Encode()
+
+	method, body := "GET", io.Reader(nil)
+	if *bodyFile == "-" {
+		method, body = "POST", stdin
+	} else if *bodyFile != "" {
+		f, err := os.Open(*bodyFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		method, body = "POST", f
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	_, err = io.Copy(stdout, resp.Body)
+	return err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/timob/httpize"
+)
+
+type word string
+
+func (w word) Check() error {
+	return nil
+}
+
+var _ = httpize.AddType("Word", func(s string) httpize.Arg { return word(s) })
+
+var _ = httpize.Handle("/api/Greet?thing Word&greeting Word=Hello", httpize.Func2(func(thing, greeting word) (io.WriterTo, *httpize.Settings, error) {
+	return strings.NewReader(string(greeting) + " " + string(thing)), nil, nil
+}, "thing", "greeting"))
+
+var _ = httpize.Handle("/api/Echo?data Body", httpize.Func1(func(data httpize.Body) (io.WriterTo, *httpize.Settings, error) {
+	b, err := io.ReadAll(data.Reader)
+	return bytes.NewReader(b), nil, err
+}, "data"))
+
+var _ = httpize.HandleMethods("/api")
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.DefaultServeMux)
+	defer server.Close()
+	base := server.URL + "/api"
+
+	var out bytes.Buffer
+	if err := run([]string{"-url", base, "list"}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Greet\t/api/Greet thing Word [greeting Word=Hello]\n") {
+		t.Errorf("got %q", out.String())
+	}
+
+	out.Reset()
+	if err := run([]string{"-url", base, "call", "-param", "thing=world", "Greet"}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "Hello world" {
+		t.Errorf("got %q", out.String())
+	}
+
+	out.Reset()
+	if err := run([]string{"-url", base, "call", "-body", "-", "Echo"}, strings.NewReader("echo"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "echo" {
+		t.Errorf("got %q", out.String())
+	}
+
+	err := run([]string{"-url", base, "call", "Greet"}, nil, &out)
+	if err == nil || !strings.HasPrefix(err.Error(), "400 Bad Request") {
+		t.Errorf("got %v", err)
+	}
+	if err := run([]string{"-url", base, "call", "Missing"}, nil, &out); err == nil {
+		t.Error("no error calling missing method")
+	}
+}
@@ -0,0 +1,220 @@
+package httpize
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// compressors by content coding
+var compressors = map[string]func(io.Writer) io.WriteCloser{
+	"gzip": func(w io.Writer) io.WriteCloser {
+		return gzipWriter(w, 0)
+	},
+}
+
+// pools of gzip writers by level, from gzip.HuffmanOnly
+var gzipPools [gzip.BestCompression - gzip.HuffmanOnly + 1]sync.Pool
+
+// gzipWriter returns a gzip writer writing to w from a pool, it is put back
+// when closed. level is as per Settings.GzipLevel.
+func gzipWriter(w io.Writer, level int) io.WriteCloser {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	pool := &gzipPools[level-gzip.HuffmanOnly]
+	if gw, ok := pool.Get().(*gzip.Writer); ok {
+		gw.Reset(w)
+		return pooledGzip{gw, pool}
+	}
+	gw, _ := gzip.NewWriterLevel(w, level)
+	return pooledGzip{gw, pool}
+}
+
+type pooledGzip struct {
+	*gzip.Writer
+	pool *sync.Pool
+}
+
+func (g pooledGzip) Close() error {
+	err := g.Writer.Close()
+	g.pool.Put(g.Writer)
+	return err
+}
+
+// content codings in order of preference when the client accepts several
+// equally, codings not listed come last
+var codingPreference = []string{"zstd", "br", "gzip"}
+
+func codingRank(coding string) int {
+	for i, c := range codingPreference {
+		if c == coding {
+			return i
+		}
+	}
+	return len(codingPreference)
+}
+
+// RegisterCompressor adds a content coding that can be used to compress
+// responses when Settings.Compress is set. coding is the Content-Encoding name,
+// eg "br" or "zstd". f returns an io.WriteCloser that writes compressed data to w, it is
+// closed at the end of the response. gzip is always available, using pooled
+// writers at Settings.GzipLevel, and can not be replaced. Allways returns
+// true.
+func RegisterCompressor(coding string, f func(io.Writer) io.WriteCloser) bool {
+	compressors[coding] = f
+	return true
+}
+
+// negotiateCoding chooses a content coding using the request Accept-Encoding
+// header. If all is false only gzip is considered, otherwise all registered
+// codings are. The coding with the highest q-value is chosen, if equal zstd is
+// preferred, then br, then gzip, then the one listed first. Returns "" for no
+// compression, when no coding is acceptable or identity is listed with a
+// higher q-value.
+// ok is false if no coding is acceptable and identity has been excluded.
+func negotiateCoding(header string, all bool) (coding string, ok bool) {
+	qs := parseQualities(header)
+	identityQ, starQ, star := 1.0, 0.0, false
+	listed := make(map[string]bool)
+	for _, q := range qs {
+		listed[q.value] = true
+		switch q.value {
+		case "*":
+			starQ, star = q.q, true
+		case "identity":
+			identityQ = q.q
+		}
+	}
+	if star && !listed["identity"] {
+		identityQ = starQ
+	}
+
+	available := func(coding string) bool {
+		_, ok := compressors[coding]
+		return ok && (all || coding == "gzip")
+	}
+	best, bestQ := "", 0.0
+	consider := func(coding string, q float64) {
+		if q > bestQ || q == bestQ && q > 0 && codingRank(coding) < codingRank(best) {
+			best, bestQ = coding, q
+		}
+	}
+	for _, q := range qs {
+		if available(q.value) {
+			consider(q.value, q.q)
+		}
+	}
+	if star {
+		var unlisted []string
+		for coding := range compressors {
+			if !listed[coding] && available(coding) {
+				unlisted = append(unlisted, coding)
+			}
+		}
+		sort.Strings(unlisted)
+		for _, coding := range unlisted {
+			consider(coding, starQ)
+		}
+	}
+
+	if best != "" && (bestQ >= identityQ || !listed["identity"] && !star) {
+		return best, true
+	}
+	return "", identityQ > 0
+}
+
+// content types not compressed when Options.NoCompressTypes is nil
+var defaultNoCompressTypes = []string{
+	"image/*", "audio/*", "video/*",
+	"application/gzip", "application/zip", "application/zstd",
+	"application/x-bzip2", "application/x-7z-compressed", "application/x-xz",
+}
+
+func matchContentType(types []string, contentType string) bool {
+	for _, t := range types {
+		if t == contentType || strings.HasSuffix(t, "/*") &&
+			strings.HasPrefix(contentType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressible reports whether responses with contentType can be compressed.
+func (o *Options) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(contentType)
+	}
+	if len(o.CompressTypes) > 0 && !matchContentType(o.CompressTypes, mediaType) {
+		return false
+	}
+	noCompress := o.NoCompressTypes
+	if noCompress == nil {
+		noCompress = defaultNoCompressTypes
+	}
+	return !matchContentType(noCompress, mediaType)
+}
+
+// compressWriter compresses what is written to it with coding once at least
+// minSize bytes have been written, setting the Content-Encoding header. If
+// closed before that the bytes are written uncompressed.
+type compressWriter struct {
+	resp    http.ResponseWriter
+	out     io.Writer
+	coding  string
+	minSize int
+	// gzip level
+	level int
+	buf   []byte
+	cw    io.WriteCloser
+}
+
+func (c *compressWriter) start() error {
+	c.resp.Header().Set("Content-Encoding", c.coding)
+	if tag := c.resp.Header().Get("ETag"); strings.HasSuffix(tag, "\"") {
+		// the compressed representation needs a different strong ETag
+		c.resp.Header().Set("ETag", tag[:len(tag)-1]+"-"+c.coding+"\"")
+	}
+	if c.coding == "gzip" {
+		c.cw = gzipWriter(c.out, c.level)
+	} else {
+		c.cw = compressors[c.coding](c.out)
+	}
+	if len(c.buf) > 0 {
+		_, err := c.cw.Write(c.buf)
+		c.buf = nil
+		return err
+	}
+	return nil
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.cw == nil {
+		if len(c.buf)+len(p) < c.minSize || len(p) == 0 {
+			c.buf = append(c.buf, p...)
+			return len(p), nil
+		}
+		err := c.start()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return c.cw.Write(p)
+}
+
+func (c *compressWriter) Close() error {
+	if c.cw == nil {
+		_, err := c.out.Write(c.buf)
+		return err
+	}
+	return c.cw.Close()
+}
@@ -0,0 +1,200 @@
+package httpize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A fake compressor that upper cases what is written to it
+type upperWriter struct {
+	w io.Writer
+}
+
+func (u upperWriter) Write(p []byte) (int, error) {
+	return u.w.Write(bytes.ToUpper(p))
+}
+
+func (u upperWriter) Close() error {
+	return nil
+}
+
+var _ = RegisterCompressor("br", func(w io.Writer) io.WriteCloser {
+	return upperWriter{w}
+})
+
+// A fake compressor that reverses what is written to it
+type reverseWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (r *reverseWriter) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	return len(p), nil
+}
+
+func (r *reverseWriter) Close() error {
+	for i, j := 0, len(r.buf)-1; i < j; i, j = i+1, j-1 {
+		r.buf[i], r.buf[j] = r.buf[j], r.buf[i]
+	}
+	_, err := r.w.Write(r.buf)
+	return err
+}
+
+var _ = RegisterCompressor("zstd", func(w io.Writer) io.WriteCloser {
+	return &reverseWriter{w: w}
+})
+
+func Compressed(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return bytes.NewBufferString("compressed"), &Settings{Compress: true}, nil
+}
+
+var _ = Handle("/Compressed", CallerFunc(Compressed))
+
+func TestCompress(t *testing.T) {
+	h := GetHandlerForPattern("/Compressed")
+
+	for accept, coding := range map[string]string{
+		"":                        "",
+		"br":                      "br",
+		"gzip, br":                "br",
+		"gzip, deflate, br, zstd": "zstd",
+		"zstd;q=0.9, gzip":        "gzip",
+		"gzip;q=0.5, br":          "br",
+		"deflate, br;q=0.1":       "br",
+		"gzip;q=0, br;q=0":        "",
+		"identity, compress":      "",
+		"br;q=0.2, gzip;q=0.4":    "gzip",
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/Compressed", nil)
+		request.Header.Set("Accept-Encoding", accept)
+		h.ServeHTTP(recorder, request)
+		if coding == "406" {
+			checkCode(t, recorder, 406)
+			continue
+		}
+		checkCode(t, recorder, 200)
+		if v := recorder.Header().Get("Content-Encoding"); v != coding {
+			t.Fatalf("Accept-Encoding %s: Content-Encoding %s", accept, v)
+		}
+		if recorder.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatal("Vary header missing")
+		}
+		if coding == "br" && recorder.Body.String() != "COMPRESSED" {
+			t.Fatal("incorrect response")
+		}
+		if coding == "zstd" && recorder.Body.String() != "desserpmoc" {
+			t.Fatal("incorrect response")
+		}
+		if coding == "" && recorder.Body.String() != "compressed" {
+			t.Fatal("incorrect response")
+		}
+	}
+}
+
+func TestNegotiateCoding(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		all    bool
+		coding string
+		ok     bool
+	}{
+		{"br, gzip", false, "gzip", true},
+		{"br", false, "", true},
+		{"   ", false, "", true},
+		{"GZIP", false, "gzip", true},
+		{"gzip;q=0", false, "", true},
+		{"gzip;q=0.5, identity", true, "", true},
+		{"gzip;q=0.5, identity;q=0.4", true, "gzip", true},
+		{"*", true, "zstd", true},
+		{"*;q=0.5, gzip", true, "gzip", true},
+		{"br;q=0, *", true, "zstd", true},
+		{"identity;q=0", true, "", false},
+		{"*;q=0", true, "", false},
+		{"*;q=0, gzip", false, "gzip", true},
+		{"*;q=0, identity", false, "", true},
+	} {
+		coding, ok := negotiateCoding(c.header, c.all)
+		if coding != c.coding || ok != c.ok {
+			t.Fatalf("%q: got %s %v", c.header, coding, ok)
+		}
+	}
+}
+
+// compressedText returns a Caller responding with text of contentType
+func compressedText(text, contentType string) Caller {
+	return CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+		s := &Settings{Compress: true, CompressMinSize: 8, ContentType: contentType, Vary: []string{"Cookie"}}
+		return bytes.NewBufferString(text), s, nil
+	})
+}
+
+var _ = Handle("/CompressedShort", compressedText("short", "text/plain"))
+var _ = Handle("/CompressedLong", compressedText("long enough", "text/plain"))
+var _ = Handle("/CompressedImage", compressedText("long enough", "image/png"))
+
+func TestCompressFilter(t *testing.T) {
+	for name, coding := range map[string]string{
+		"CompressedShort": "",
+		"CompressedLong":  "br",
+		"CompressedImage": "",
+	} {
+		h := GetHandlerForPattern("/" + name)
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/"+name, nil)
+		request.Header.Set("Accept-Encoding", "br")
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 200)
+		if v := recorder.Header().Get("Content-Encoding"); v != coding {
+			t.Fatalf("%s: Content-Encoding %s", name, v)
+		}
+		vary := strings.Join(recorder.Header().Values("Vary"), ", ")
+		if name == "CompressedImage" && vary != "Cookie" || name != "CompressedImage" && vary != "Cookie, Accept-Encoding" {
+			t.Fatalf("%s: Vary %s", name, vary)
+		}
+	}
+
+	o := &Options{}
+	if o.compressible("image/png") || !o.compressible("text/html; charset=utf-8") {
+		t.Fatal("default NoCompressTypes not used")
+	}
+	o = &Options{CompressTypes: []string{"text/*"}, NoCompressTypes: []string{"text/csv"}}
+	if !o.compressible("text/html") || o.compressible("text/csv") || o.compressible("application/json") {
+		t.Fatal("CompressTypes or NoCompressTypes not used")
+	}
+}
+
+func TestGzipWriterPool(t *testing.T) {
+	for _, level := range []int{0, 1, 9} {
+		for i := 0; i < 2; i++ {
+			buf := new(bytes.Buffer)
+			w := gzipWriter(buf, level)
+			io.WriteString(w, "pooled")
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			r, err := gzip.NewReader(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := io.ReadAll(r); string(b) != "pooled" {
+				t.Fatalf("level %d: got %s", level, b)
+			}
+		}
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		w := gzipWriter(io.Discard, 0)
+		w.Write([]byte("pooled"))
+		w.Close()
+	})
+	if allocs > 10 {
+		t.Fatalf("%f allocations per gzip writer", allocs)
+	}
+}
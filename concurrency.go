@@ -0,0 +1,57 @@
+package httpize
+
+import (
+	"context"
+	"time"
+)
+
+// ConcurrencyLimit caps the number of calls of a method being handled at
+// once, use WithConcurrencyLimit to set it. Create with NewConcurrencyLimit.
+type ConcurrencyLimit struct {
+	sem  chan struct{}
+	wait time.Duration
+}
+
+// NewConcurrencyLimit returns a ConcurrencyLimit allowing n calls at once.
+// When n calls are being handled a request waits up to wait for one to
+// finish, if wait is 0 or it waits longer it gets a 503 error.
+func NewConcurrencyLimit(n int, wait time.Duration) *ConcurrencyLimit {
+	return &ConcurrencyLimit{make(chan struct{}, n), wait}
+}
+
+// acquire waits for a call to be allowed, the returned func must be called
+// when it is finished.
+func (l *ConcurrencyLimit) acquire(ctx context.Context) (func(), error) {
+	release := func() { <-l.sem }
+	select {
+	case l.sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if l.wait <= 0 {
+		return nil, Non500Error{503, "too many concurrent requests", ""}
+	}
+
+	t := time.NewTimer(l.wait)
+	defer t.Stop()
+	select {
+	case l.sem <- struct{}{}:
+		return release, nil
+	case <-t.C:
+		return nil, Non500Error{503, "too many concurrent requests", ""}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WithConcurrencyLimit returns a Caller for c to be given to Handle, calls of
+// c, including writing their responses, are limited by l. Optional interfaces
+// c implements are still used by the handler.
+func WithConcurrencyLimit(c Caller, l *ConcurrencyLimit) Caller {
+	return concurrencyCaller{c, l}
+}
+
+type concurrencyCaller struct {
+	Caller
+	limit *ConcurrencyLimit
+}
@@ -0,0 +1,84 @@
+package httpize
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var reportStarted = make(chan bool)
+var reportRelease = make(chan bool)
+
+var _ = Handle("/Report", WithConcurrencyLimit(CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	reportStarted <- true
+	<-reportRelease
+	return strings.NewReader("report"), nil, nil
+}), NewConcurrencyLimit(1, 10*time.Millisecond)))
+
+func TestConcurrencyLimit(t *testing.T) {
+	h := GetHandlerForPattern("/Report")
+
+	done := make(chan int)
+	get := func() {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/Report", nil)
+		h.ServeHTTP(recorder, request)
+		done <- recorder.Code
+	}
+
+	go get()
+	<-reportStarted
+	go get()
+	if code := <-done; code != 503 {
+		t.Fatalf("got %d while limit reached", code)
+	}
+	reportRelease <- true
+	if code := <-done; code != 200 {
+		t.Fatalf("got %d", code)
+	}
+
+	go get()
+	<-reportStarted
+	reportRelease <- true
+	if code := <-done; code != 200 {
+		t.Fatalf("got %d after limited call finished", code)
+	}
+}
+
+var slowRelease = make(chan bool)
+
+var slowLimit = NewConcurrencyLimit(1, 0)
+
+var _ = HandleWithOptions("/Slow/Report", WithConcurrencyLimit(CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	<-slowRelease
+	return strings.NewReader("report"), nil, nil
+}), slowLimit), &Options{Timeout: 10 * time.Millisecond})
+
+func TestConcurrencyLimitTimeout(t *testing.T) {
+	h := GetHandlerForPattern("/Slow/Report")
+	get := func() int {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/Slow/Report", nil)
+		h.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := get(); code != 504 {
+		t.Fatalf("got %d", code)
+	}
+	// the timed out call is still running
+	if code := get(); code != 503 {
+		t.Fatalf("got %d while timed out call running", code)
+	}
+	slowRelease <- true
+	for len(slowLimit.sem) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() { slowRelease <- true }()
+	if code := get(); code != 200 {
+		t.Fatalf("got %d after timed out call returned", code)
+	}
+}
@@ -0,0 +1,83 @@
+package httpize
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+)
+
+// CSRF protects handlers from cross site request forgery using double submit
+// cookies. Set it in Options.CSRF. Requests without the token cookie get one
+// set, POST requests must give the cookie value in the header or query
+// parameter, otherwise they get a 403 error. CSRFToken gives the token to
+// embed in forms or pages.
+type CSRF struct {
+	// Name of the cookie, "csrf_token" if ""
+	Cookie string
+	// Header with the token, "X-CSRF-Token" if ""
+	Header string
+	// Query parameter with the token, "csrf_token" if "". It is not treated
+	// as an unknown argument.
+	Param string
+}
+
+func (c *CSRF) names() (cookie, header, param string) {
+	cookie, header, param = c.Cookie, c.Header, c.Param
+	if cookie == "" {
+		cookie = "csrf_token"
+	}
+	if header == "" {
+		header = "X-CSRF-Token"
+	}
+	if param == "" {
+		param = "csrf_token"
+	}
+	return
+}
+
+type csrfKey struct{}
+
+// CSRFToken returns the CSRF token of the request the context is from, as
+// given to a ContextCaller, or "" if the handler does not use CSRF.
+func CSRFToken(ctx context.Context) string {
+	t, _ := ctx.Value(csrfKey{}).(string)
+	return t
+}
+
+// check checks the token of POST requests and sets the cookie if the request
+// has none. Returns req with the token in its context.
+func (c *CSRF) check(resp http.ResponseWriter, req *http.Request) (*http.Request, error) {
+	cookieName, header, param := c.names()
+	token := ""
+	if cookie, err := req.Cookie(cookieName); err == nil {
+		token = cookie.Value
+	}
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		given := req.Header.Get(header)
+		if given == "" {
+			given = req.URL.Query().Get(param)
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(given)) != 1 {
+			return req, Non500Error{403, "invalid CSRF token", ""}
+		}
+	}
+
+	if token == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return req, err
+		}
+		token = base64.RawURLEncoding.EncodeToString(b)
+		http.SetCookie(resp, &http.Cookie{
+			Name:     cookieName,
+			Value:    token,
+			Path:     "/",
+			Secure:   req.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return req.WithContext(context.WithValue(req.Context(), csrfKey{}, token)), nil
+}
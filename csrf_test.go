@@ -0,0 +1,55 @@
+package httpize
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type csrfForm struct{}
+
+func (csrfForm) Call(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, nil
+}
+
+func (csrfForm) CallContext(ctx context.Context, args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return strings.NewReader(CSRFToken(ctx)), nil, nil
+}
+
+var _ = HandleWithOptions("/CSRF/Form", csrfForm{}, &Options{CSRF: new(CSRF)})
+
+func TestCSRF(t *testing.T) {
+	h := GetHandlerForPattern("/CSRF/Form")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/CSRF/Form", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "csrf_token" || cookies[0].Value != recorder.Body.String() {
+		t.Fatalf("token cookie %v, body %s", cookies, recorder.Body.String())
+	}
+	token := cookies[0].Value
+
+	for url, code := range map[string]int{
+		"http://host/CSRF/Form":                     403,
+		"http://host/CSRF/Form?csrf_token=wrong":    403,
+		"http://host/CSRF/Form?csrf_token=" + token: 200,
+	} {
+		recorder = httptest.NewRecorder()
+		request, _ = http.NewRequest("POST", url, nil)
+		request.AddCookie(cookies[0])
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, code)
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "http://host/CSRF/Form", nil)
+	request.AddCookie(cookies[0])
+	request.Header.Set("X-CSRF-Token", token)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+}
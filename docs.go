@@ -0,0 +1,63 @@
+package httpize
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+var docsTemplate = template.Must(template.New("docs").Funcs(template.FuncMap{
+	"join": strings.Join,
+	"json": func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; }
+code { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Methods}}
+<h2 id="{{.Name}}">{{.Name}}</h2>
+<p>{{join .Verbs ", "}} <code>{{.Path}}</code> responds with <code>{{.ContentType}}</code></p>
+{{if .Args}}<table>
+<tr><th>Argument</th><th>Type</th><th>Required</th><th>Default</th><th>Schema</th></tr>
+{{range .Args}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{if .Required}}yes{{else}}no{{end}}</td><td>{{.Default}}</td><td>{{if .Schema}}<code>{{json .Schema}}</code>{{end}}</td></tr>
+{{end}}</table>{{end}}
+<p>Example: <code>{{.Curl}}</code></p>
+{{end}}
+</body>
+</html>
+`))
+
+// HandleDocs adds a handler at path responding with an HTML page documenting
+// the methods returned by Methods, with the title title. Errors writing the
+// page are logged with the Logger of DefaultOptions. Always returns true.
+func HandleDocs(path, title string) bool {
+	http.HandleFunc(path, func(resp http.ResponseWriter, req *http.Request) {
+		methods := Methods()
+		base := baseURL(req)
+		for i := range methods {
+			methods[i].Curl = methods[i].CurlCommand(base)
+		}
+		resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := docsTemplate.Execute(resp, struct {
+			Title   string
+			Methods []MethodInfo
+		}{title, methods})
+		if err != nil {
+			DefaultOptions.logger().Errorf("httpize: docs: %s", err)
+		}
+	})
+	return true
+}
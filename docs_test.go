@@ -0,0 +1,50 @@
+package httpize
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var _ = HandleDocs("/docs", "Test API")
+
+func TestDocs(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/docs", nil)
+	http.DefaultServeMux.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"<title>Test API</title>",
+		`<h2 id="Upload">Upload</h2>`,
+		"<td>name</td><td>SafeString</td><td>yes</td>",
+		"<code>curl &#39;http://host/Greet?thing=&lt;thing&gt;&#39;</code>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+// closedWriter fails writes as if the connection was closed
+type closedWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (closedWriter) Write([]byte) (int, error) {
+	return 0, errors.New("closed")
+}
+
+func TestDocsLogger(t *testing.T) {
+	l := new(testLogger)
+	DefaultOptions.Logger = l
+	defer func() { DefaultOptions.Logger = nil }()
+
+	request, _ := http.NewRequest("GET", "http://host/docs", nil)
+	http.DefaultServeMux.ServeHTTP(closedWriter{httptest.NewRecorder()}, request)
+	if len(l.errors) != 1 {
+		t.Fatalf("logged %v", l.errors)
+	}
+}
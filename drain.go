@@ -0,0 +1,113 @@
+package httpize
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DrainRetryAfter is the Retry-After of the 503 error responded with while
+// draining.
+var DrainRetryAfter = 10 * time.Second
+
+// requests being handled by all handlers, and whether draining
+var requests struct {
+	sync.Mutex
+	n        int
+	draining bool
+	// closed when n becomes 0
+	idle chan struct{}
+}
+
+// startRequest counts a request as being handled, returns false if draining.
+func startRequest() bool {
+	requests.Lock()
+	defer requests.Unlock()
+	if requests.draining {
+		return false
+	}
+	requests.n++
+	return true
+}
+
+// holdRequest counts a request being handled again, even if draining, for a
+// call that returns after the request is handled. endRequest must be called
+// when it returns.
+func holdRequest() {
+	requests.Lock()
+	requests.n++
+	requests.Unlock()
+}
+
+func endRequest() {
+	requests.Lock()
+	defer requests.Unlock()
+	requests.n--
+	if requests.n == 0 && requests.idle != nil {
+		close(requests.idle)
+		requests.idle = nil
+	}
+}
+
+// Drain makes all handlers respond to new requests with a 503 error, with
+// Retry-After as per DrainRetryAfter, and waits for requests being handled
+// to finish, including writing their response bodies and calls that exceeded
+// Options.Timeout. Returns ctx.Err() if ctx is done first. Handlers keep
+// refusing requests until Resume is called.
+func Drain(ctx context.Context) error {
+	requests.Lock()
+	requests.draining = true
+	if requests.n == 0 {
+		requests.Unlock()
+		return nil
+	}
+	if requests.idle == nil {
+		requests.idle = make(chan struct{})
+	}
+	idle := requests.idle
+	requests.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resume makes handlers handle requests again after Drain.
+func Resume() {
+	requests.Lock()
+	requests.draining = false
+	requests.Unlock()
+}
+
+// InFlight returns the number of requests being handled by all handlers,
+// counting requests whose call exceeded Options.Timeout until it returns.
+func InFlight() int {
+	requests.Lock()
+	defer requests.Unlock()
+	return requests.n
+}
+
+// Shutdown drains handlers, as Drain does, then shuts down srv with
+// srv.Shutdown, so requests being handled by handlers, including long
+// response bodies, aren't cut off. srv can be nil to only drain. Returns
+// ctx.Err() if ctx is done first.
+func Shutdown(ctx context.Context, srv *http.Server) error {
+	if err := Drain(ctx); err != nil {
+		return err
+	}
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
+
+// draining reports whether Drain has been called without Resume.
+func draining() bool {
+	requests.Lock()
+	defer requests.Unlock()
+	return requests.draining
+}
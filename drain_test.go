@@ -0,0 +1,119 @@
+package httpize
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var drainStarted = make(chan bool)
+var drainRelease = make(chan bool)
+
+var _ = Handle("/Draining", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	drainStarted <- true
+	<-drainRelease
+	return strings.NewReader("done"), nil, nil
+}))
+
+func TestDrain(t *testing.T) {
+	h := GetHandlerForPattern("/Draining")
+	defer Resume()
+
+	inFlight := httptest.NewRecorder()
+	finished := make(chan bool)
+	go func() {
+		request, _ := http.NewRequest("GET", "http://host/Draining", nil)
+		h.ServeHTTP(inFlight, request)
+		finished <- true
+	}()
+	<-drainStarted
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Drain(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Drain returned %v with request in flight", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Draining", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 503)
+	if recorder.Header().Get("Retry-After") != "10" {
+		t.Fatal("Retry-After not set")
+	}
+
+	drained := make(chan error)
+	go func() { drained <- Drain(context.Background()) }()
+	drainRelease <- true
+	<-finished
+	if err := <-drained; err != nil || inFlight.Body.String() != "done" {
+		t.Fatalf("Drain returned %v, in flight request got %s", err, inFlight.Body.String())
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	defer Resume()
+	server := httptest.NewServer(GetHandlerForPattern("/Draining"))
+	defer server.Close()
+
+	body := make(chan string)
+	go func() {
+		resp, err := http.Get(server.URL + "/Draining")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		body <- string(b)
+	}()
+	<-drainStarted
+	if n := InFlight(); n != 1 {
+		t.Fatalf("InFlight = %d", n)
+	}
+
+	shutdown := make(chan error)
+	go func() { shutdown <- Shutdown(context.Background(), server.Config) }()
+	drainRelease <- true
+	if b := <-body; b != "done" {
+		t.Fatalf("in flight request got %s", b)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if n := InFlight(); n != 0 {
+		t.Fatalf("InFlight = %d", n)
+	}
+}
+
+var stalledRelease = make(chan bool)
+
+var _ = HandleWithOptions("/Stalled", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	<-stalledRelease
+	return strings.NewReader("done"), nil, nil
+}), &Options{Timeout: 10 * time.Millisecond})
+
+func TestDrainTimedOut(t *testing.T) {
+	defer Resume()
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Stalled", nil)
+	GetHandlerForPattern("/Stalled").ServeHTTP(recorder, request)
+	checkCode(t, recorder, 504)
+	if n := InFlight(); n == 0 {
+		t.Fatal("timed out call not in flight")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Drain(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Drain returned %v with call in flight", err)
+	}
+	stalledRelease <- true
+	if err := Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
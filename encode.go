@@ -0,0 +1,155 @@
+package httpize
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+type encoder struct {
+	contentType string
+	encode      func(io.Writer, interface{}, *Settings) error
+}
+
+// encoders that can be selected by Settings.Encode, by content type
+var encoders = map[string]encoder{
+	"application/json": {"application/json", func(w io.Writer, v interface{}, s *Settings) error {
+		return json.NewEncoder(w).Encode(v)
+	}},
+	"application/xml": {"application/xml", encodeXML},
+}
+
+// short names for encoders
+var encoderNames = map[string]string{
+	"json": "application/json",
+	"xml":  "application/xml",
+}
+
+// RegisterEncoder adds an encoder for values returned using Value. contentType
+// is the Content-Type of the encoded value and the name used to select the
+// encoder with Settings.Encode. enc writes v encoded to the io.Writer.
+// Allways returns true.
+func RegisterEncoder(contentType string, enc func(io.Writer, interface{}) error) bool {
+	encoders[contentType] = encoder{contentType, func(w io.Writer, v interface{}, s *Settings) error {
+		return enc(w, v)
+	}}
+	return true
+}
+
+// lookupEncoder returns the encoder with the short name or content type name.
+func lookupEncoder(name string) (encoder, bool) {
+	if contentType, ok := encoderNames[name]; ok {
+		name = contentType
+	}
+	e, ok := encoders[name]
+	return e, ok
+}
+
+// encodeXML writes the XML header followed by v. If Settings.XMLRoot is set
+// v is enclosed in a root element of that name.
+func encodeXML(w io.Writer, v interface{}, s *Settings) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if s.XMLRoot == "" {
+		err = enc.Encode(v)
+		if err != nil {
+			return err
+		}
+		return enc.Flush()
+	}
+
+	root := xml.StartElement{Name: xml.Name{Local: s.XMLRoot}}
+	err = enc.EncodeToken(root)
+	if err != nil {
+		return err
+	}
+	err = enc.Encode(v)
+	if err != nil {
+		return err
+	}
+	err = enc.EncodeToken(root.End())
+	if err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// Value returns an io.WriterTo holding v, so that a Caller can return a value
+// to be encoded as per Settings.Encode. If Settings.Encode is not set v is
+// written as formatted by fmt.Fprint.
+func Value(v interface{}) io.WriterTo {
+	return value{v}
+}
+
+type value struct {
+	v interface{}
+}
+
+func (v value) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprint(w, v.v)
+	return int64(n), err
+}
+
+// encodeValue encodes the value held by w, if w was returned by Value, using
+// the encoder named name into a buffer from bufs. The value is encoded before
+// anything is written so that encoding errors can still be responded to with
+// an error code.
+func encodeValue(name string, s *Settings, w io.WriterTo, bufs *buffers) (io.WriterTo, error) {
+	e, ok := lookupEncoder(name)
+	if !ok {
+		return nil, errors.New("httpize: unknown encoding " + name)
+	}
+	v, ok := w.(value)
+	if !ok {
+		return w, nil
+	}
+	buf := bufs.get()
+	err := e.encode(buf, v.v, s)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+var callbackRegexp = regexp.MustCompile(`^[a-zA-Z_$][0-9a-zA-Z_$]*(\.[a-zA-Z_$][0-9a-zA-Z_$]*)*$`)
+
+// validCallback reports whether s can be used as a JSONP callback, it must be
+// a JavaScript identifier or dotted identifiers.
+func validCallback(s string) bool {
+	return len(s) <= 128 && callbackRegexp.MatchString(s)
+}
+
+// formatJSON indents the JSON written by w if pretty is true, and wraps it in a
+// call to callback if not "". Buffers are got from bufs.
+func formatJSON(w io.WriterTo, pretty bool, callback string, bufs *buffers) (io.WriterTo, error) {
+	src := bufs.get()
+	_, err := w.WriteTo(src)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufs.get()
+	if callback != "" {
+		buf.WriteString("/**/" + callback + "(")
+	}
+	if pretty {
+		err = json.Indent(buf, bytes.TrimSpace(src.Bytes()), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		buf.Write(bytes.TrimSpace(src.Bytes()))
+	}
+	if callback != "" {
+		buf.WriteString(");")
+	}
+	buf.WriteString("\n")
+	return buf, nil
+}
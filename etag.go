@@ -0,0 +1,37 @@
+package httpize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// etag buffers what w writes in a buffer from bufs and returns the buffer and
+// a strong ETag for it.
+func etag(w io.WriterTo, bufs *buffers) (io.WriterTo, string, error) {
+	buf := bufs.get()
+	_, err := w.WriteTo(buf)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return buf, "\"" + hex.EncodeToString(sum[:16]) + "\"", nil
+}
+
+// etagMatch reports whether the If-None-Match header value matches tag. Tags
+// are compared weakly, and a tag with a content coding appended, as done when
+// the response is compressed, matches the tag without.
+func etagMatch(ifNoneMatch, tag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	tag = strings.Trim(tag, "\"")
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.Trim(strings.TrimPrefix(strings.TrimSpace(t), "W/"), "\"")
+		if t == tag || strings.HasPrefix(t, tag+"-") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package httpize
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var _ = Handle("/Tagged", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	s := NewSettings().WithGzip(true).WithCache(60)
+	s.AutoETag = true
+	return bytes.NewBufferString("tagged"), s, nil
+}))
+
+func TestAutoETag(t *testing.T) {
+	h := GetHandlerForPattern("/Tagged")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Tagged", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	tag := recorder.Header().Get("ETag")
+	if tag == "" || recorder.Body.String() != "tagged" {
+		t.Fatal("ETag header missing or incorrect response")
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Tagged", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	gzipTag := recorder.Header().Get("ETag")
+	if gzipTag == tag || gzipTag != tag[:len(tag)-1]+"-gzip\"" {
+		t.Fatalf("compressed ETag invalid: %s", gzipTag)
+	}
+
+	for _, ifNoneMatch := range []string{tag, "W/" + tag, "\"x\", " + gzipTag, "*"} {
+		recorder = httptest.NewRecorder()
+		request, _ = http.NewRequest("GET", "http://host/Tagged", nil)
+		request.Header.Set("If-None-Match", ifNoneMatch)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 304)
+		if recorder.Body.Len() != 0 || recorder.Header().Get("Cache-Control") != "max-age=60" {
+			t.Fatal("incorrect 304 response")
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Tagged", nil)
+	request.Header.Set("If-None-Match", "\"other\"")
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+}
@@ -0,0 +1,56 @@
+package httpize
+
+import (
+	"expvar"
+	"net/http"
+	"sync"
+)
+
+var expvarMu sync.Mutex
+
+// methodVars are the expvar counters of a method.
+type methodVars struct {
+	requests, errors, inFlight *expvar.Int
+}
+
+// newMethodVars returns the counters for method in the expvar.Map published as
+// name, adding them if needed.
+func newMethodVars(name, method string) methodVars {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	m, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		m = expvar.NewMap(name)
+	}
+	vars, ok := m.Get(method).(*expvar.Map)
+	if !ok {
+		vars = new(expvar.Map)
+		m.Set(method, vars)
+	}
+	get := func(key string) *expvar.Int {
+		v, ok := vars.Get(key).(*expvar.Int)
+		if !ok {
+			v = new(expvar.Int)
+			vars.Set(key, v)
+		}
+		return v
+	}
+	return methodVars{get("requests"), get("errors"), get("in_flight")}
+}
+
+// expvars wraps next to count requests in the expvar.Map named by
+// Options.Expvar. Responses with a 4xx or 5xx status code are counted as
+// errors.
+func (h *handler) expvars(next http.Handler) http.Handler {
+	vars := newMethodVars(h.options.Expvar, h.name)
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		vars.requests.Add(1)
+		vars.inFlight.Add(1)
+		defer vars.inFlight.Add(-1)
+		sw := &statusWriter{ResponseWriter: resp}
+		next.ServeHTTP(sw, req)
+		if sw.code >= 400 {
+			vars.errors.Add(1)
+		}
+	})
+}
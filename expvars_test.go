@@ -0,0 +1,29 @@
+package httpize
+
+import (
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var _ = HandleWithOptions("/Counted", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return strings.NewReader("ok"), nil, nil
+}), &Options{Expvar: "httpize_test"})
+
+func TestExpvar(t *testing.T) {
+	h := GetHandlerForPattern("/Counted")
+	for _, url := range []string{"http://host/Counted", "http://host/Counted?x=1"} {
+		request, _ := http.NewRequest("GET", url, nil)
+		h.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	vars := expvar.Get("httpize_test").(*expvar.Map).Get("Counted").(*expvar.Map)
+	for k, want := range map[string]string{"requests": "2", "errors": "1", "in_flight": "0"} {
+		if got := vars.Get(k).String(); got != want {
+			t.Errorf("%s = %s, want %s", k, got, want)
+		}
+	}
+}
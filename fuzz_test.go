@@ -0,0 +1,61 @@
+package httpize
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var _ = Handle("/Fuzzed?c Color&d Color=red", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return strings.NewReader(string(args["c"].(Color)) + string(args["d"].(Color))), nil, nil
+}))
+
+// FuzzArgs checks the Args of all types added with AddType don't panic when
+// made from s or checked, and that Check errors are 4xx errors or plain errors
+// that become 400 errors.
+func FuzzArgs(f *testing.F) {
+	for _, s := range []string{"", "a", "red", "<script>", "\x00", "%zz", strings.Repeat("x", 1000)} {
+		f.Add(s)
+	}
+	var names []string
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, name := range names {
+			err := types[name](s).Check()
+			var httpErr HTTPError
+			if errors.As(err, &httpErr) && httpErr.HTTPStatus() >= 500 {
+				t.Errorf("%s: Check(%q) gave a %d error", name, s, httpErr.HTTPStatus())
+			}
+		}
+	})
+}
+
+// FuzzServeHTTP checks that any query gives a 200 response or a 4xx error.
+func FuzzServeHTTP(f *testing.F) {
+	for _, q := range []string{"", "c=red", "c=blue&d=green", "c=red&c=red", "c=pink", "x=1", "c=%zz", "c=red;d=red", "&&=&"} {
+		f.Add(q)
+	}
+	h := GetHandlerForPattern("/Fuzzed?c Color&d Color=red")
+	f.Fuzz(func(t *testing.T, q string) {
+		if strings.ContainsAny(q, "# \t\r\n") {
+			// not a valid request line
+			return
+		}
+		request, err := http.NewRequest("GET", "http://host/Fuzzed?"+q, nil)
+		if err != nil {
+			return
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		if code := recorder.Code; code != 200 && (code < 400 || code > 499) {
+			t.Errorf("query %q: status %d", q, code)
+		}
+	})
+}
@@ -1,43 +1,146 @@
 package httpize
 
 import (
-	"bufio"
-	"compress/gzip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
+	"os"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 type handler struct {
+	name            string
+	path            string // URL path handled
 	caller          Caller
 	argBuilders     argBuilderSlice
 	defaultSettings *Settings
+	// Settings given with WithSettings
+	settings *Settings
+	options  *Options
+	// roles given with WithRoles
+	roles []string
+	// RateLimit given with WithRateLimit
+	rateLimit *RateLimit
+	// ConcurrencyLimit given with WithConcurrencyLimit
+	concurrency *ConcurrencyLimit
+	// IPFilter given with WithIPFilter
+	ipFilter *IPFilter
+	// set by compile
+	dispatch  http.Handler
+	queryArgs map[string]bool
+	// interfaces caller implements, nil if not
+	contextCaller ContextCaller
+	validator     CallValidator
+	beforeCaller  BeforeCaller
+	afterCaller   AfterCaller
+	// set if Options.Collapse
+	flights *flightGroup
 }
 
 // Settings has options for handling HTTP request.
 type Settings struct {
-	// Seconds to cache for
+	// Seconds to cache for, sets the Expires header and Cache-Control
+	// max-age for GET requests. For authenticated requests Expires is not set
+	// and Cache-Control is private unless CachePublic is set.
 	Cache int64
+	// Cache-Control directive private, for responses that are per user and
+	// must not be stored by shared caches, or public, for responses that can
+	// be stored by shared caches even for authenticated requests
+	CachePrivate bool
+	CachePublic  bool
+	// Cache-Control directives no-store, no-cache and must-revalidate
+	NoStore        bool
+	NoCache        bool
+	MustRevalidate bool
 	// Content-Type header
 	ContentType string
+	// Charset appended to ContentType, unless "" or ContentType has one
+	Charset string
 	// Use Gzip
 	Gzip bool
+	// Use any content coding added with RegisterCompressor, or gzip,
+	// choosing by the client's preference
+	Compress bool
+	// Responses smaller than this many bytes are not compressed
+	CompressMinSize int
+	// gzip compression level, from gzip.HuffmanOnly to gzip.BestCompression,
+	// 0 for gzip.DefaultCompression
+	GzipLevel int
+	// HTTP status code of the response, 0 for 200. If 204 a Caller can
+	// return a nil io.WriterTo for no response body.
+	StatusCode int
+	// If > 0 responses of up to this many bytes, after compression, are
+	// buffered so that the Content-Length header can be set. Larger
+	// responses are streamed.
+	BufferSize int
+	// What to do when writing the response body fails after some of it has
+	// been written. Use BufferSize to have responses buffered so an error
+	// response can be made instead.
+	StreamError StreamErrorMode
+	// If the io.WriterTo is an *os.File, or Reader was given one, copy it
+	// directly to the connection so the OS sendfile optimization can be used.
+	// The response is not compressed or buffered.
+	Sendfile bool
+	// Buffer the response to set the ETag header from a hash of it, and
+	// respond 304 Not Modified if it matches If-None-Match. For GET requests
+	// only and not used with Sendfile.
+	AutoETag bool
+	// Maximum number of bytes, before compression, of the response body. If
+	// exceeded writing the response stops with an error, with Sendfile files
+	// larger than it get a 500 error without anything being written. Replaces
+	// Options.MaxResponseSize if > 0.
+	MaxResponseSize int64
+	// Have the browser download the response rather than display it
+	Attachment bool
+	// File name for the response in the Content-Disposition header
+	Filename string
+	// Security headers to use instead of Options.Security
+	Security *SecurityHeaders
+	// Extra values for the Vary header. Accept-Encoding is added when the
+	// response may be compressed and Accept when Negotiate is used.
+	Vary []string
+	// Extra response headers, they replace headers set from other Settings
+	Headers http.Header
+	// Cookies to set with Set-Cookie headers
+	Cookies []*http.Cookie
+	// Encoding of values returned using Value, "json" for encoding/json,
+	// "xml" for encoding/xml or the content type of an encoder added with
+	// RegisterEncoder. The Content-Type header is set by the encoding,
+	// ContentType is not used.
+	Encode string
+	// Encodings to choose from using the request Accept header, in order
+	// of preference. Used instead of Encode if not empty. If none are
+	// acceptable a 406 error is responded.
+	Negotiate []string
+	// Name of a root element to enclose the value in when Encode is "xml",
+	// if "" the value is the root
+	XMLRoot string
 }
 
-// SetToDefault sets: Cache = 0, Content-type = text/html, 
-// gzip false.
+// SetToDefault sets: Cache = 0, Content-type = text/html,
+// Charset = utf-8, gzip false. All other fields are set to their zero value.
 func (s *Settings) SetToDefault() {
+	*s = Settings{}
 	s.Cache = 0
 	s.ContentType = "text/html"
+	s.Charset = "utf-8"
 	s.Gzip = false
 }
 
-// Non500Error is an error that can be returned by exported methods or an Arg 
-// Check() method. Errors are considered 500 errors unless specifically of 
-// this type.
+// Non500Error is an error that can be returned by exported methods or an Arg
+// Check() method. Errors are considered 500 errors unless specifically of
+// this type, or another HTTPError.
 type Non500Error struct {
 	ErrorCode int
 	ErrorStr  string
@@ -48,114 +151,1082 @@ func (e Non500Error) Error() string {
 	return e.ErrorStr
 }
 
+func (e Non500Error) HTTPStatus() int {
+	return e.ErrorCode
+}
+
+// HTTPHeader has Location for 301, 302 and 303 errors.
+func (e Non500Error) HTTPHeader() http.Header {
+	if e.ErrorCode == 301 || e.ErrorCode == 302 || e.ErrorCode == 303 {
+		return http.Header{"Location": {e.Location}}
+	}
+	return nil
+}
+
+func (e Non500Error) HTTPBody() io.Reader {
+	return nil
+}
+
+// HTTPError is an error that when returned by a Caller, a CallValidator or an
+// Arg Check() method gives the status code, headers and body of the error
+// response. Non500Error, RetryableError and ResponseError are HTTPErrors.
+type HTTPError interface {
+	error
+	HTTPStatus() int
+	// Headers to set on the response, can be nil
+	HTTPHeader() http.Header
+	// Response body, if nil the error message is used. Closed if it is an
+	// io.Closer
+	HTTPBody() io.Reader
+}
+
+// ResponseError is an HTTPError with any headers and body, eg a 401 error with
+// WWW-Authenticate or a 409 error with a JSON document describing the
+// conflict. Set Content-Type in Header when giving a Body.
+type ResponseError struct {
+	Code    int
+	Message string
+	Header  http.Header
+	Body    io.Reader
+}
+
+func (e ResponseError) Error() string {
+	return e.Message
+}
+
+func (e ResponseError) HTTPStatus() int {
+	return e.Code
+}
+
+func (e ResponseError) HTTPHeader() http.Header {
+	return e.Header
+}
+
+func (e ResponseError) HTTPBody() io.Reader {
+	return e.Body
+}
+
+// RetryableError is an error like Non500Error, that when ErrorCode is 429 or
+// 503 responds with a Retry-After header saying how long the client should
+// wait before trying again.
+type RetryableError struct {
+	ErrorCode  int
+	ErrorStr   string
+	RetryAfter time.Duration
+}
+
+func (e RetryableError) Error() string {
+	return e.ErrorStr
+}
+
+func (e RetryableError) HTTPStatus() int {
+	return e.ErrorCode
+}
+
+// HTTPHeader has Retry-After in seconds for 429 and 503 errors.
+func (e RetryableError) HTTPHeader() http.Header {
+	if (e.ErrorCode == 429 || e.ErrorCode == 503) && e.RetryAfter > 0 {
+		secs := int64((e.RetryAfter + time.Second - 1) / time.Second)
+		return http.Header{"Retry-After": {strconv.FormatInt(secs, 10)}}
+	}
+	return nil
+}
+
+func (e RetryableError) HTTPBody() io.Reader {
+	return nil
+}
+
+// StreamErrorMode is what a handler does when writing the response body fails
+// after some of it has been written to the client.
+type StreamErrorMode int
+
+const (
+	// Log the error and try to write an error response, which the client
+	// may see appended to the body. This is the default.
+	StreamErrorDefault StreamErrorMode = iota
+	// Log the error and abort the connection so that the client sees an
+	// incomplete response.
+	StreamErrorAbort
+	// Log the error and send it in the Httpize-Error trailer.
+	StreamErrorTrailer
+)
+
+// TemplateResult can be returned by a Caller as the io.WriterTo to have the
+// response body be the template called Name in Options.Templates executed with
+// Data. The template is executed before anything is written, so if it fails a
+// 500 error is responded.
+type TemplateResult struct {
+	Name string
+	Data interface{}
+}
+
+// WriteTo always fails, a TemplateResult can only be written by a handler.
+func (t TemplateResult) WriteTo(w io.Writer) (int64, error) {
+	return 0, errors.New("httpize: TemplateResult written outside of handler")
+}
+
+func (t TemplateResult) execute(templates *template.Template, bufs *buffers) (io.WriterTo, error) {
+	if templates == nil {
+		return nil, errors.New("httpize: TemplateResult returned with no Options.Templates")
+	}
+	buf := bufs.get()
+	err := templates.ExecuteTemplate(buf, t.Name, t.Data)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Redirect returns an io.WriterTo that a Caller can return to respond with a
+// redirect to location with the 3xx status code, as done by http.Redirect.
+func Redirect(code int, location string) io.WriterTo {
+	return redirect{code, location}
+}
+
+type redirect struct {
+	code     int
+	location string
+}
+
+func (r redirect) WriteTo(w io.Writer) (int64, error) {
+	return 0, errors.New("httpize: Redirect written outside of handler")
+}
+
+// setHeaders sets the response headers from s.Headers and s.Cookies.
+func setHeaders(resp http.ResponseWriter, s *Settings) {
+	for k, v := range s.Headers {
+		resp.Header()[k] = append([]string(nil), v...)
+	}
+	for _, c := range s.Cookies {
+		http.SetCookie(resp, c)
+	}
+}
+
+// cacheControl returns the Cache-Control header value for s, get is whether
+// the request method is GET.
+func cacheControl(s *Settings, get, authenticated bool) string {
+	var d []string
+	if s.CachePrivate || authenticated && !s.CachePublic {
+		d = append(d, "private")
+	} else if s.CachePublic {
+		d = append(d, "public")
+	}
+	if s.Cache > 0 && get {
+		d = append(d, "max-age="+strconv.FormatInt(s.Cache, 10))
+	}
+	if s.NoStore {
+		d = append(d, "no-store")
+	}
+	if s.NoCache {
+		d = append(d, "no-cache")
+	}
+	if s.MustRevalidate {
+		d = append(d, "must-revalidate")
+	}
+	return strings.Join(d, ", ")
+}
+
+// contentDisposition returns the Content-Disposition header value for s. A non
+// ASCII Filename is given as filename* encoded as per RFC 5987, with an ASCII
+// only filename for old clients.
+func contentDisposition(s *Settings) string {
+	v := "inline"
+	if s.Attachment {
+		v = "attachment"
+	}
+	if s.Filename == "" {
+		return v
+	}
+
+	ascii := true
+	fallback := make([]byte, 0, len(s.Filename))
+	for _, r := range s.Filename {
+		switch {
+		case r >= utf8.RuneSelf || r < ' ' || r == 0x7f:
+			ascii = false
+			fallback = append(fallback, '_')
+		case r == '"' || r == '\\':
+			fallback = append(fallback, '\\', byte(r))
+		default:
+			fallback = append(fallback, byte(r))
+		}
+	}
+	v += "; filename=\"" + string(fallback) + "\""
+	if ascii {
+		return v
+	}
+
+	const hex = "0123456789ABCDEF"
+	encoded := make([]byte, 0, len(s.Filename)*3)
+	for _, b := range []byte(s.Filename) {
+		if 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' ||
+			strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			encoded = append(encoded, b)
+		} else {
+			encoded = append(encoded, '%', hex[b>>4], hex[b&15])
+		}
+	}
+	return v + "; filename*=UTF-8''" + string(encoded)
+}
+
+// limitWriter writes up to n bytes to w, then fails.
+var errResponseTooLarge = errors.New("httpize: response larger than maximum size")
+
+type limitWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= l.n {
+		n, err := l.w.Write(p)
+		l.n -= int64(n)
+		return n, err
+	}
+	n, err := l.w.Write(p[:l.n])
+	l.n -= int64(n)
+	if err == nil {
+		err = errResponseTooLarge
+	}
+	return n, err
+}
+
+// lengthWriter buffers up to limit bytes written to it. If Close is called
+// before more than limit bytes are written the Content-Length header is set
+// and the buffered bytes are written to resp, otherwise it streams to resp.
+// code is the status code to write, if not 0, it is written just before the
+// first bytes so headers can be set until then.
+type lengthWriter struct {
+	resp      http.ResponseWriter
+	limit     int
+	code      int
+	buf       *bytes.Buffer
+	streaming bool
+}
+
+func (l *lengthWriter) writeHeader() {
+	if l.code != 0 {
+		l.resp.WriteHeader(l.code)
+	}
+}
+
+func (l *lengthWriter) Write(p []byte) (int, error) {
+	if l.streaming {
+		return l.resp.Write(p)
+	}
+	if l.buf.Len()+len(p) <= l.limit {
+		return l.buf.Write(p)
+	}
+
+	l.streaming = true
+	l.writeHeader()
+	if l.buf.Len() > 0 {
+		_, err := l.resp.Write(l.buf.Bytes())
+		l.buf.Reset()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return l.resp.Write(p)
+}
+
+func (l *lengthWriter) Close() error {
+	if l.streaming {
+		return nil
+	}
+	l.resp.Header().Set("Content-Length", strconv.Itoa(l.buf.Len()))
+	l.writeHeader()
+	_, err := l.resp.Write(l.buf.Bytes())
+	return err
+}
+
+// sendFile writes the rest of f to resp with the Content-Length header set,
+// using resp's io.ReaderFrom so that sendfile is used when possible. If max >
+// 0 and the rest of f is larger nothing is written and started is false,
+// files of unknown size are copied with a limit of max.
+func sendFile(resp http.ResponseWriter, f *os.File, code int, max int64) (started bool, err error) {
+	size := int64(-1)
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		if pos, err := f.Seek(0, io.SeekCurrent); err == nil {
+			size = fi.Size() - pos
+		}
+	}
+	if max > 0 && size > max {
+		return false, errResponseTooLarge
+	}
+	if size >= 0 {
+		resp.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if code != 0 {
+		resp.WriteHeader(code)
+	}
+	if max > 0 && size < 0 {
+		_, err = io.Copy(&limitWriter{resp, max}, f)
+		return true, err
+	}
+	if rf, ok := resp.(io.ReaderFrom); ok {
+		_, err = rf.ReadFrom(f)
+		return true, err
+	}
+	_, err = io.Copy(resp, f)
+	return true, err
+}
+
+// clearHeaders removes the headers set for a response that won't be written,
+// so they aren't sent with an error response instead, and the error isn't
+// cached as the response would have been.
+func clearHeaders(resp http.ResponseWriter) {
+	for _, k := range []string{"Content-Encoding", "Content-Disposition", "Content-Length",
+		"Cache-Control", "Expires", "ETag", "Last-Modified", "Vary"} {
+		resp.Header().Del(k)
+	}
+}
+
 func fiveHundredError(resp http.ResponseWriter) {
 	http.Error(resp, "error", 500)
 }
 
-func providerError(err error, resp http.ResponseWriter) {
-	if e, ok := err.(Non500Error); ok {
-		if e.ErrorCode == 301 || e.ErrorCode == 302 || e.ErrorCode == 303 {
-			// might need to unset headers in here
-			resp.Header().Set("Location", e.Location)
+// writeError responds with an error status code, using Options.ErrorRenderer
+// if set. Otherwise the body is err.Error(), or "error" for 500 errors.
+// If Options.Debug is set the body of 500 errors is err.Error() too.
+func (h *handler) writeError(resp http.ResponseWriter, req *http.Request, status int, err error) {
+	if status == 500 && h.options.Debug {
+		err = debugError{err}
+	}
+	if h.options.ErrorRenderer != nil {
+		h.options.ErrorRenderer(resp, req, status, err)
+		return
+	}
+	if _, ok := err.(debugError); status == 500 && !ok {
+		fiveHundredError(resp)
+		return
+	}
+	http.Error(resp, err.Error(), status)
+}
+
+// debugError is a 500 error whose message can be shown to clients, as
+// Options.Debug is set.
+type debugError struct {
+	error
+}
+
+func (e debugError) Unwrap() error {
+	return e.error
+}
+
+// JSONErrorRenderer can be used as Options.ErrorRenderer to respond with
+// errors as JSON objects like {"code":404,"message":"not found"}. The message
+// of 500 errors is "error", unless Options.Debug is set. ArgErrors are listed in an "errors" array.
+func JSONErrorRenderer(resp http.ResponseWriter, req *http.Request, status int, err error) {
+	msg := "error"
+	if _, ok := err.(debugError); status != 500 || ok {
+		msg = err.Error()
+	}
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	resp.WriteHeader(status)
+	var argErrors ArgErrors
+	errors.As(err, &argErrors)
+	json.NewEncoder(resp).Encode(struct {
+		Code    int        `json:"code"`
+		Message string     `json:"message"`
+		Errors  []ArgError `json:"errors,omitempty"`
+	}{status, msg, argErrors})
+}
+
+func (h *handler) providerError(err error, resp http.ResponseWriter, req *http.Request, start time.Time) {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		err = Non500Error{413, "request body larger than " + strconv.FormatInt(maxBytes.Limit, 10) + " bytes", ""}
+	}
+	var e HTTPError
+	if !errors.As(err, &e) {
+		if status, ok := h.options.mappedStatus(err); ok {
+			e = Non500Error{status, http.StatusText(status), ""}
 		}
-		http.Error(resp, e.ErrorStr, e.ErrorCode)
+	}
+	if e != nil {
+		for k, v := range e.HTTPHeader() {
+			resp.Header()[k] = v
+		}
+		body := e.HTTPBody()
+		if body == nil {
+			h.writeError(resp, req, e.HTTPStatus(), e)
+			return
+		}
+		if c, ok := body.(io.Closer); ok {
+			defer c.Close()
+		}
+		resp.WriteHeader(e.HTTPStatus())
+		io.Copy(resp, body)
 	} else {
-		fiveHundredError(resp)
-		log.Print(err)
+		h.writeError(resp, req, 500, err)
+		h.logError(err, req, start, 500)
+	}
+}
+
+// logError logs err, with the method name, request and time since start as
+// fields if the Logger is a StructuredLogger.
+func (h *handler) logError(err error, req *http.Request, start time.Time, status int) {
+	l := h.options.logger()
+	sl, ok := l.(StructuredLogger)
+	if !ok {
+		l.Errorf("%s", err)
+		return
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	sl.ErrorAttrs(err.Error(),
+		slog.String("method", h.name),
+		slog.String("remote_addr", req.RemoteAddr),
+		slog.String("url", req.URL.String()),
+		slog.Duration("duration", time.Since(start)),
+		slog.Int("status", status),
+	)
+}
+
+// panicError is a value recovered from a panic with the stack of where it
+// happened.
+type panicError struct {
+	v     interface{}
+	stack []byte
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.v, e.stack)
+}
+
+// recovered handles v recovered from a panic while handling req. It logs the
+// stack, responds with a 500 error and calls Options.PanicHook.
+func (h *handler) recovered(v interface{}, resp http.ResponseWriter, req *http.Request, start time.Time) {
+	p, ok := v.(panicError)
+	if !ok {
+		p = panicError{v, debug.Stack()}
+	}
+	h.logError(p, req, start, 500)
+	h.writeError(resp, req, 500, p)
+	if h.options.PanicHook != nil {
+		h.options.PanicHook(req, p.v, p.stack)
+	}
+}
+
+// call calls the Caller, using CallContext if it is a ContextCaller. A panic
+// in the Caller is returned as a panicError. If
+// Options.Timeout is set and ctx is done before the call returns a 504 error
+// is returned, the call is left to finish in the background and counted as an
+// in flight request until it does. returned is called when the Caller returns.
+func (h *handler) call(ctx context.Context, args map[string]Arg, returned func()) (io.WriterTo, *Settings, error) {
+	call := func() (w io.WriterTo, s *Settings, err error) {
+		defer returned()
+		defer func() {
+			if v := recover(); v != nil {
+				w, s, err = nil, nil, panicError{v, debug.Stack()}
+			}
+		}()
+		if h.contextCaller != nil {
+			return h.contextCaller.CallContext(ctx, args)
+		}
+		return h.caller.Call(args)
+	}
+	if h.options.Timeout <= 0 {
+		return call()
+	}
+
+	type result struct {
+		writerTo io.WriterTo
+		settings *Settings
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		w, s, err := call()
+		done <- result{w, s, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.writerTo, r.settings, r.err
+	case <-ctx.Done():
+		holdRequest()
+		go func() {
+			defer endRequest()
+			if c, ok := (<-done).writerTo.(io.Closer); ok {
+				c.Close()
+			}
+		}()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, nil, Non500Error{504, "method " + h.name + " timed out", ""}
+		}
+		return nil, nil, ctx.Err()
+	}
+}
+
+// secondCall returns a func that calls f the second time it is called.
+func secondCall(f func()) func() {
+	var n atomic.Int32
+	return func() {
+		if n.Add(1) == 2 {
+			f()
+		}
 	}
 }
 
+type methodNameKey struct{}
+
+// MethodName returns the name of the method, the last part of the pattern
+// path, a request is for. It can be used by middleware added with
+// Options.Use. Returns "" if the request is not being handled by a handler
+// with middleware.
+func MethodName(req *http.Request) string {
+	name, _ := req.Context().Value(methodNameKey{}).(string)
+	return name
+}
+
+// compile works out what ServeHTTP does from the handlers options and
+// arguments, so this isn't done for every request.
+func (h *handler) compile() {
+	h.queryArgs = make(map[string]bool, len(h.argBuilders))
+	for i := range h.argBuilders {
+		if h.argBuilders[i].query() {
+			h.queryArgs[h.argBuilders[i].key] = true
+		}
+	}
+	if h.options.Collapse {
+		h.flights = &flightGroup{flights: make(map[string]*flight)}
+	}
+	h.contextCaller, _ = h.caller.(ContextCaller)
+	h.validator, _ = h.caller.(CallValidator)
+	h.beforeCaller, _ = h.caller.(BeforeCaller)
+	h.afterCaller, _ = h.caller.(AfterCaller)
+
+	var next http.Handler = http.HandlerFunc(h.serve)
+	if h.options.Expvar != "" {
+		next = h.expvars(next)
+	}
+	if h.options.Metrics != nil {
+		next = h.metrics(next)
+	}
+	if h.options.Tracer != nil {
+		next = h.traced(next)
+	}
+	if h.options.AccessLog != nil {
+		next = h.accessLog(next)
+	}
+	if len(h.options.middleware) == 0 {
+		h.dispatch = next
+		return
+	}
+	for i := len(h.options.middleware) - 1; i >= 0; i-- {
+		next = h.options.middleware[i](next)
+	}
+	h.dispatch = http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), methodNameKey{}, h.name)))
+	})
+}
+
 func (h *handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	h.dispatch.ServeHTTP(resp, req)
+}
+
+func (h *handler) serve(resp http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	// set once a call is made
+	var audit func(status int)
+	if h.options.Audit != nil {
+		sw := &statusWriter{ResponseWriter: resp}
+		resp = sw
+		defer func() {
+			if audit != nil {
+				audit(sw.code)
+			}
+		}()
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			h.recovered(v, resp, req, start)
+		}
+	}()
+	if !startRequest() {
+		h.providerError(RetryableError{503, "server is shutting down", DrainRetryAfter}, resp, req, start)
+		return
+	}
+	defer endRequest()
 	if req.Method != "GET" && req.Method != "POST" {
-		fiveHundredError(resp)
-		log.Printf("Unsupported HTTP method: %s", req.Method)
+		h.writeError(resp, req, 500, errors.New("unsupported HTTP method "+req.Method))
+		h.options.logger().Infof("Unsupported HTTP method: %s", req.Method)
 		return
 	}
 
-	pathParts := strings.Split(req.URL.Path, "/")
-	methodName := pathParts[len(pathParts)-1]
+	if h.options.Security != nil {
+		h.options.Security.set(resp.Header())
+	}
 
-	getParam, err := url.ParseQuery(req.URL.RawQuery)
+	req, err := h.checkRequest(resp, req, start)
 	if err != nil {
-		fiveHundredError(resp)
-		log.Print(err)
+		h.providerError(err, resp, req, start)
 		return
 	}
 
-	paramCount := len(h.argBuilders)
-	args := make(map[string]Arg)
-	foundArgs, err := h.argBuilders.buildArgs(args, func(s string) (string, bool) {
-		v, ok := getParam[s]
-		if !ok {
-			return "", false
+	if h.options.Idempotency != nil && req.Method == "POST" && req.Header.Get("Idempotency-Key") != "" {
+		var done func()
+		var served bool
+		if resp, req, done, served = h.idempotent(resp, req, start); served {
+			return
 		}
-		return v[0], true
-	})
+		defer done()
+	}
 
+	if h.options.ResponseCache != nil || h.flights != nil {
+		var done func()
+		var served bool
+		if resp, done, served = h.shareResponse(resp, req); served {
+			return
+		}
+		defer done()
+	}
+
+	args, pretty, callback, session, err := h.callArgs(resp, req)
 	if err != nil {
-		providerError(err, resp)
+		h.providerError(err, resp, req, start)
 		return
 	}
 
-	getParamCount := 0
-	for _, v := range getParam {
-		for i := 0; i < len(v); i++ {
-			getParamCount++
+	// called when the Caller returns, which can be after serve returns if
+	// Options.Timeout is exceeded
+	callDone := func() {}
+	if h.concurrency != nil {
+		release, err := h.concurrency.acquire(req.Context())
+		if err != nil {
+			h.providerError(err, resp, req, start)
+			return
 		}
+		// the call is limited until it returns and its response is written
+		release = secondCall(release)
+		defer release()
+		callDone = release
 	}
 
-	if foundArgs != paramCount || foundArgs != getParamCount {
-		fiveHundredError(resp)
-		log.Printf("%s called incorrectly (URL: %s)", methodName, req.URL.String())
-		return
+	ctx := req.Context()
+	if h.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.options.Timeout)
+		defer cancel()
+	}
+
+	callStart := time.Now()
+	if h.options.Audit != nil {
+		entry := AuditEntry{Time: callStart, Method: h.name, Args: h.argBuilders.auditArgs(args, false)}
+		if p := PrincipalOf(req); p != nil {
+			entry.Principal = p.Name()
+		}
+		audit = func(status int) {
+			entry.Status, entry.Duration = status, time.Since(callStart)
+			h.options.Audit.Audit(entry)
+		}
+	}
+	span := spanOf(req)
+	if span != nil {
+		span.SetArgs(h.argBuilders.auditArgs(args, !h.options.TraceArgValues))
+	}
+	writerTo, settings, err := h.call(ctx, args, callDone)
+	if span != nil && err != nil {
+		span.SetError(err)
+	}
+	var bufs buffers
+	defer bufs.release()
+	if h.afterCaller != nil {
+		h.afterCaller.AfterCall(h.name, time.Since(callStart), err)
+	}
+	if p, ok := err.(panicError); ok {
+		panic(p)
 	}
 
-	writerTo, settings, err := h.caller.Call(args)
+	if c, ok := writerTo.(io.Closer); ok {
+		defer c.Close()
+		if h.options.Timeout > 0 {
+			// unblock a stalled body copy
+			stop := context.AfterFunc(ctx, func() { c.Close() })
+			defer stop()
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok && h.options.Timeout > 0 {
+		http.NewResponseController(resp).SetWriteDeadline(deadline)
+	}
 
 	if err != nil {
-		providerError(err, resp)
+		h.providerError(err, resp, req, start)
 		return
 	}
 
+	if session != nil && session.changed {
+		err = h.options.Sessions.Save(resp, req, session.values)
+		if err != nil {
+			h.providerError(err, resp, req, start)
+			return
+		}
+	}
+
+	h.writeResponse(resp, req, start, writerTo, h.responseSettings(req, settings), pretty, callback, &bufs)
+}
+
+// checkRequest checks req against the request limits, IP filters,
+// authentication, rate limits and CSRF protection. Returns req with the
+// values added to its context by the checks.
+func (h *handler) checkRequest(resp http.ResponseWriter, req *http.Request, start time.Time) (*http.Request, error) {
+	if max := h.options.MaxPathLength; max > 0 && len(req.URL.EscapedPath()) > max {
+		return req, Non500Error{414, "path longer than " + strconv.Itoa(max) + " bytes", ""}
+	}
+	if max := h.options.MaxQueryLength; max > 0 && len(req.URL.RawQuery) > max {
+		return req, Non500Error{414, "query longer than " + strconv.Itoa(max) + " bytes", ""}
+	}
+	if max := h.options.MaxParams; max > 0 && countParams(req.URL.RawQuery) > max {
+		return req, Non500Error{400, "more than " + strconv.Itoa(max) + " query parameters", ""}
+	}
+
+	for _, f := range []*IPFilter{h.options.IPFilter, h.ipFilter} {
+		if f == nil {
+			continue
+		}
+		var err error
+		if req, err = f.check(req); err != nil {
+			return req, err
+		}
+	}
+
+	req, err := h.authenticate(req, start)
+	if err != nil {
+		return req, err
+	}
+
+	if err := h.checkRateLimits(req); err != nil {
+		return req, err
+	}
+
+	if h.options.CSRF != nil {
+		return h.options.CSRF.check(resp, req)
+	}
+	return req, nil
+}
+
+// callArgs returns the arguments of the call for req, and the values of
+// Options.PrettyParam and Options.JSONPParam. session is the Session argument
+// if the method has one. Runs the CallValidator and BeforeCaller if the Caller
+// is one.
+func (h *handler) callArgs(resp http.ResponseWriter, req *http.Request) (args map[string]Arg, pretty bool, callback string, session *Session, err error) {
+	if max := h.options.MaxBodySize; max > 0 && req.ContentLength > max {
+		return nil, false, "", nil, Non500Error{413, "request body larger than " + strconv.FormatInt(max, 10) + " bytes", ""}
+	}
+
+	// methods without query arguments don't need the query parsed
+	if len(h.queryArgs) == 0 && req.URL.RawQuery == "" && h.options.SignedURLs == nil {
+		args = make(map[string]Arg, len(h.argBuilders))
+	} else if args, pretty, callback, err = h.parseArgs(req); err != nil {
+		return nil, false, "", nil, err
+	}
+
+	var body io.Reader = http.NoBody
+	if req.Body != nil {
+		body = req.Body
+	}
+	if h.options.MaxBodySize > 0 && req.Body != nil {
+		body = http.MaxBytesReader(resp, req.Body, h.options.MaxBodySize)
+	}
+	h.argBuilders.buildBodyArgs(args, body)
+	if err := h.argBuilders.buildPrincipalArgs(args, PrincipalOf(req)); err != nil {
+		return nil, false, "", nil, unauthorized(h.options.Authenticator, err.Error())
+	}
+	session, err = h.argBuilders.buildSessionArgs(args, h.options.Sessions, req)
+	if err != nil {
+		return nil, false, "", nil, err
+	}
+
+	if h.validator != nil {
+		if err = h.validator.ValidateCall(h.name, args); err != nil {
+			return nil, false, "", nil, err
+		}
+	}
+
+	if h.beforeCaller != nil {
+		if err = h.beforeCaller.BeforeCall(h.name, args, req); err != nil {
+			return nil, false, "", nil, err
+		}
+	}
+	return args, pretty, callback, session, nil
+}
+
+// responseSettings returns the Settings for the response, settings returned
+// by the Caller or else the handler's, with Options.SettingsFunc applied.
+func (h *handler) responseSettings(req *http.Request, settings *Settings) *Settings {
+	if settings == nil {
+		settings = h.settings
+	}
+	if settings == nil {
+		settings = h.options.Settings
+	}
 	if settings == nil {
 		settings = h.defaultSettings
 	}
+	if h.options.SettingsFunc != nil {
+		if s := h.options.SettingsFunc(req, settings.Clone()); s != nil {
+			settings = s
+		}
+	}
+	return settings
+}
+
+// writeResponse writes the response for the value returned by the Caller
+// using settings.
+func (h *handler) writeResponse(resp http.ResponseWriter, req *http.Request, start time.Time, writerTo io.WriterTo, settings *Settings, pretty bool, callback string, bufs *buffers) {
+	if settings.Security != nil {
+		settings.Security.set(resp.Header())
+	}
+
+	if writerTo == nil && settings.StatusCode == http.StatusNoContent {
+		setHeaders(resp, settings)
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if writerTo == nil {
+		methodName := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+		err := errors.New("method " + methodName + " returned nil WriterTo and error")
+		h.writeError(resp, req, 500, err)
+		h.logError(err, req, start, 500)
+		return
+	}
+
+	if r, ok := writerTo.(redirect); ok {
+		setHeaders(resp, settings)
+		http.Redirect(resp, req, r.location, r.code)
+		return
+	}
+
+	encode := settings.Encode
+	if len(settings.Negotiate) > 0 {
+		resp.Header().Add("Vary", "Accept")
+		encode = negotiateEncoder(req.Header.Get("Accept"), settings.Negotiate)
+		if encode == "" {
+			h.providerError(Non500Error{406, "not acceptable", ""}, resp, req, start)
+			return
+		}
+	}
+
+	var err error
+	if t, ok := writerTo.(TemplateResult); ok {
+		writerTo, err = t.execute(h.options.Templates, bufs)
+		if err != nil {
+			h.providerError(err, resp, req, start)
+			return
+		}
+	}
 
-	if settings.ContentType != "" {
-		resp.Header().Set("Content-Type", settings.ContentType)
+	contentType := settings.ContentType
+	if encode != "" {
+		writerTo, err = encodeValue(encode, settings, writerTo, bufs)
+		if err != nil {
+			h.providerError(err, resp, req, start)
+			return
+		}
+		e, _ := lookupEncoder(encode)
+		contentType = e.contentType
+		if contentType == "application/json" && (pretty || callback != "") {
+			writerTo, err = formatJSON(writerTo, pretty, callback, bufs)
+			if err != nil {
+				h.providerError(err, resp, req, start)
+				return
+			}
+			if callback != "" {
+				contentType = "application/javascript"
+			}
+		}
+	}
+
+	if contentType != "" && encode == "" && settings.Charset != "" &&
+		!strings.Contains(contentType, "charset=") {
+		contentType += "; charset=" + settings.Charset
+	}
+
+	if contentType != "" {
+		resp.Header().Set("Content-Type", contentType)
 	}
 
-	if settings.Cache > 0 && req.Method == "GET" {
+	authenticated := req.Header.Get("Authorization") != "" || PrincipalOf(req) != nil
+	if settings.Cache > 0 && req.Method == "GET" && !authenticated {
 		t := time.Unix(time.Now().UTC().Unix()+settings.Cache, 0).UTC()
 		resp.Header().Set("Expires", t.Format(time.RFC1123))
 	}
 
-	var compress io.Writer
-	if settings.Gzip && strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
-		resp.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(resp)
-		compress = gz
-		defer gz.Close()
-	} else {
-		compress = resp
+	if v := cacheControl(settings, req.Method == "GET", authenticated); v != "" {
+		resp.Header().Set("Cache-Control", v)
 	}
 
-	if writerTo == nil {
-		fiveHundredError(resp)
-		log.Printf("Method %s returned nil WriterTo and error", methodName)
+	if settings.Attachment || settings.Filename != "" {
+		resp.Header().Set("Content-Disposition", contentDisposition(settings))
+	}
+
+	setHeaders(resp, settings)
+
+	for _, v := range settings.Vary {
+		resp.Header().Add("Vary", v)
+	}
+
+	maxSize := settings.MaxResponseSize
+	if maxSize <= 0 {
+		maxSize = h.options.MaxResponseSize
+	}
+
+	if f := fileOf(writerTo); settings.Sendfile && f != nil {
+		started, err := sendFile(resp, f, settings.StatusCode, maxSize)
+		if err == nil {
+			return
+		}
+		if !started {
+			h.logError(err, req, start, 500)
+			clearHeaders(resp)
+			h.writeError(resp, req, 500, err)
+			return
+		}
+		h.logError(err, req, start, settings.StatusCode)
 		return
 	}
 
-	buffer := bufio.NewWriter(compress)
+	lw := &lengthWriter{resp: resp, limit: settings.BufferSize, code: settings.StatusCode, buf: bufs.get()}
+	var compress io.Writer = lw
+	var cw *compressWriter
+	if (settings.Gzip || settings.Compress) && h.options.compressible(contentType) {
+		resp.Header().Add("Vary", "Accept-Encoding")
+		coding, ok := negotiateCoding(req.Header.Get("Accept-Encoding"), settings.Compress)
+		if !ok {
+			h.providerError(Non500Error{406, "no acceptable content coding", ""}, resp, req, start)
+			return
+		}
+		if coding != "" {
+			cw = &compressWriter{resp: resp, out: lw, coding: coding, minSize: settings.CompressMinSize, level: settings.GzipLevel}
+			compress = cw
+		}
+	}
+
+	if settings.AutoETag && req.Method == "GET" {
+		var tag string
+		writerTo, tag, err = etag(writerTo, bufs)
+		if err != nil {
+			h.providerError(err, resp, req, start)
+			return
+		}
+		resp.Header().Set("ETag", tag)
+		if etagMatch(req.Header.Get("If-None-Match"), tag) {
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if maxSize > 0 {
+		compress = &limitWriter{compress, maxSize}
+	}
+
+	buffer := bufioWriter(compress)
+	defer putBufioWriter(buffer)
 	_, err = writerTo.WriteTo(buffer)
-	if err != nil {
+	if err == nil {
+		err = buffer.Flush()
+	}
+	if err == nil && cw != nil {
+		err = cw.Close()
+	}
+	if err == nil {
+		err = lw.Close()
+	}
+	if err == nil {
+		return
+	}
+
+	status := 500
+	if lw.streaming {
+		status = lw.code
+	}
+	h.logError(err, req, start, status)
+	if !lw.streaming {
+		// nothing written yet so can still respond with an error
+		clearHeaders(resp)
+		h.writeError(resp, req, 500, err)
+		return
+	}
+	switch settings.StreamError {
+	case StreamErrorAbort:
+		panic(http.ErrAbortHandler)
+	case StreamErrorTrailer:
+		resp.Header().Set(http.TrailerPrefix+"Httpize-Error", err.Error())
+	default:
 		fiveHundredError(resp)
-		log.Print(err)
 	}
+}
+
+// parseArgs returns the arguments from the query of req, and the values of
+// Options.PrettyParam and Options.JSONPParam.
+func (h *handler) parseArgs(req *http.Request) (args map[string]Arg, pretty bool, callback string, err error) {
+	getParam, err := parseQuery(req.URL.RawQuery)
+	if err != nil {
+		return nil, false, "", Non500Error{400, "invalid query: " + err.Error(), ""}
+	}
+
+	if h.options.SignedURLs != nil {
+		if err := h.options.SignedURLs.verify(req.URL.Path, getParam.values()); err != nil {
+			return nil, false, "", err
+		}
+		getParam.del("expires")
+		getParam.del("signature")
+	}
+
+	if a, ok := h.options.Authenticator.(QueryAuthenticator); ok && a.QueryParam() != "" {
+		getParam.del(a.QueryParam())
+	}
+	if h.options.CSRF != nil {
+		_, _, param := h.options.CSRF.names()
+		getParam.del(param)
+	}
+
+	if k := h.options.PrettyParam; k != "" {
+		v, _ := getParam.get(k, false)
+		pretty = v == "1" || v == "true"
+		getParam.del(k)
+	}
+	if k := h.options.JSONPParam; k != "" {
+		if v, ok := getParam.get(k, false); ok {
+			callback = v
+			if !validCallback(callback) {
+				return nil, false, "", Non500Error{400, "invalid parameter " + k, ""}
+			}
+			getParam.del(k)
+		}
+	}
+
+	for _, p := range getParam {
+		k := p.key
+		if !h.queryArgs[k] {
+			if h.options.IgnoreUnknown {
+				continue
+			}
+			return nil, false, "", Non500Error{400, "unknown parameter " + k, ""}
+		}
+		if h.options.Duplicates == DuplicateReject && getParam.count(k) > 1 {
+			return nil, false, "", Non500Error{400, "parameter " + k + " given more than once", ""}
+		}
+	}
+
+	args = make(map[string]Arg, len(h.argBuilders))
+	err = h.argBuilders.buildArgs(args, func(s string) (string, bool) {
+		return getParam.get(s, h.options.Duplicates == DuplicateLast)
+	}, h.options.AllArgErrors)
 
-	err = buffer.Flush()
 	if err != nil {
-		fiveHundredError(resp)
-		log.Print(err)
+		return nil, false, "", err
+	}
+	if k := h.argBuilders.missing(args); k != "" {
+		return nil, false, "", Non500Error{400, "missing parameter " + k, ""}
 	}
+	return args, pretty, callback, nil
 }
@@ -0,0 +1,34 @@
+package httpize
+
+import (
+	"context"
+	"net/http"
+)
+
+// HandleHealth adds handlers at path/healthz and path/readyz for liveness and
+// readiness probes. path is "" or like "/api". healthz always responds "ok".
+// readyz responds "ok" if ready returns nil, otherwise a 503 error, as it does
+// while draining. ready can be nil, and is given the context of the request.
+// Its errors are logged with the Logger of DefaultOptions. Always returns true.
+func HandleHealth(path string, ready func(ctx context.Context) error) bool {
+	http.HandleFunc(path+"/healthz", func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		resp.Write([]byte("ok\n"))
+	})
+	http.HandleFunc(path+"/readyz", func(resp http.ResponseWriter, req *http.Request) {
+		if draining() {
+			http.Error(resp, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		if ready != nil {
+			if err := ready(req.Context()); err != nil {
+				DefaultOptions.logger().Errorf("httpize: not ready: %s", err)
+				http.Error(resp, "not ready", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		resp.Write([]byte("ok\n"))
+	})
+	return true
+}
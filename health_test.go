@@ -0,0 +1,42 @@
+package httpize
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var notReady error
+
+var _ = HandleHealth("/health", func(ctx context.Context) error { return notReady })
+
+func TestHealth(t *testing.T) {
+	get := func(url string, code int) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", url, nil)
+		http.DefaultServeMux.ServeHTTP(recorder, request)
+		checkCode(t, recorder, code)
+	}
+
+	get("http://host/health/healthz", 200)
+	get("http://host/health/readyz", 200)
+
+	l := new(testLogger)
+	DefaultOptions.Logger = l
+	notReady = errors.New("database down")
+	get("http://host/health/readyz", 503)
+	get("http://host/health/healthz", 200)
+	notReady = nil
+	DefaultOptions.Logger = nil
+	if len(l.errors) != 1 {
+		t.Fatalf("logged %v", l.errors)
+	}
+
+	Drain(context.Background())
+	get("http://host/health/readyz", 503)
+	Resume()
+	get("http://host/health/readyz", 200)
+}
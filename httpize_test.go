@@ -51,7 +51,61 @@ func TestSimpleFunc(t *testing.T) {
 	recorder = httptest.NewRecorder()
 	request, _ = http.NewRequest("GET", "http://host/Greet?thing=Go'pher", nil)
 	h.ServeHTTP(recorder, request)
+	if recorder.Code != 400 {
+		t.Fatalf("expect 400 error code, got: %d", recorder.Code)
+	}
+}
+
+// A reader that records being closed
+type closeReader struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeReader) Close() error {
+	c.closed = true
+	return nil
+}
+
+var lastCloseReader *closeReader
+
+type ReaderFunc func(map[string]Arg) (io.Reader, error)
+
+func (f ReaderFunc) Call(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	r, err := f(args)
+	return Reader(r), nil, err
+}
+
+func ReadGreet(args map[string]Arg) (io.Reader, error) {
+	lastCloseReader = &closeReader{Reader: strings.NewReader("Hello " + string(args["thing"].(SafeString)))}
+	if args["thing"].(SafeString) == "error" {
+		return lastCloseReader, errors.New("error")
+	}
+	return lastCloseReader, nil
+}
+
+var _ = Handle("/ReadGreet?thing SafeString", ReaderFunc(ReadGreet))
+
+func TestReader(t *testing.T) {
+	h := GetHandlerForPattern("/ReadGreet?thing SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/ReadGreet?thing=Gopher", nil)
+	h.ServeHTTP(recorder, request)
+	if recorder.Body.String() != "Hello Gopher" {
+		t.Fatal("incorrect response")
+	}
+	if !lastCloseReader.closed {
+		t.Fatal("reader not closed")
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/ReadGreet?thing=error", nil)
+	h.ServeHTTP(recorder, request)
 	if recorder.Code != 500 {
 		t.Fatalf("expect 500 error code, got: %d", recorder.Code)
 	}
+	if !lastCloseReader.closed {
+		t.Fatal("reader not closed on error")
+	}
 }
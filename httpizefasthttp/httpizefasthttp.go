@@ -0,0 +1,32 @@
+//go:build fasthttp
+
+// Package httpizefasthttp serves httpize handlers from a fasthttp server. It
+// needs github.com/valyala/fasthttp and is built with -tags fasthttp.
+//
+//	httpize.Handle("/Echo?name SafeString", echo)
+//	fasthttp.ListenAndServe(":8080", httpizefasthttp.Mux())
+//
+// Requests are converted to net/http requests, so arguments are parsed and
+// checked, and Settings applied, by the httpize handlers as they are when
+// served with net/http.
+package httpizefasthttp
+
+import (
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// Handler returns a fasthttp.RequestHandler calling h, like a handler
+// returned by httpize.GetHandlerForPattern.
+func Handler(h http.Handler) fasthttp.RequestHandler {
+	return fasthttpadaptor.NewFastHTTPHandler(h)
+}
+
+// Mux returns a fasthttp.RequestHandler serving all handlers added with
+// httpize.Handle, and the other handlers added to http.DefaultServeMux, by
+// path.
+func Mux() fasthttp.RequestHandler {
+	return Handler(http.DefaultServeMux)
+}
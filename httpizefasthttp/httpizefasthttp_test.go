@@ -0,0 +1,46 @@
+//go:build fasthttp
+
+package httpizefasthttp
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/timob/httpize"
+	"github.com/valyala/fasthttp"
+)
+
+type name string
+
+func (n name) Check() error {
+	return nil
+}
+
+var _ = httpize.AddType("Name", func(s string) httpize.Arg { return name(s) })
+
+var _ = httpize.Handle("/fast/Echo?name Name", httpize.Func1(func(n name) (io.WriterTo, *httpize.Settings, error) {
+	return strings.NewReader(string(n)), nil, nil
+}, "name"))
+
+func TestMux(t *testing.T) {
+	h := Mux()
+	for _, c := range []struct {
+		uri    string
+		status int
+		body   string
+	}{
+		{"http://host/fast/Echo?name=Gopher", 200, "Gopher"},
+		{"http://host/fast/Echo", 400, ""},
+	} {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI(c.uri)
+		h(&ctx)
+		if ctx.Response.StatusCode() != c.status {
+			t.Errorf("%s: status %d", c.uri, ctx.Response.StatusCode())
+		}
+		if c.body != "" && string(ctx.Response.Body()) != c.body {
+			t.Errorf("%s: got %q", c.uri, ctx.Response.Body())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package httpizetest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("httpizetest.update", false, "update httpizetest golden files")
+
+// Golden compares the status, the headers named and the body of r with the
+// golden file testdata/name.golden, failing t if they differ. Content-Type is
+// compared if no headers are named. Run the tests with -httpizetest.update to
+// write the golden files.
+func Golden(t testing.TB, r *Response, name string, headers ...string) {
+	t.Helper()
+	if len(headers) == 0 {
+		headers = []string{"Content-Type"}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d\n", r.Status)
+	for _, h := range headers {
+		for _, v := range r.Header.Values(h) {
+			fmt.Fprintf(&buf, "%s: %s\n", h, v)
+		}
+	}
+	buf.WriteString("\n")
+	buf.Write(r.Body)
+
+	file := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("%s, run with -httpizetest.update to write it", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("response differs from %s, got:\n%s\nwant:\n%s", file, buf.Bytes(), want)
+	}
+}
@@ -0,0 +1,121 @@
+// Package httpizetest has helpers for testing httpize handlers.
+//
+//	r := httpizetest.Call(h, "Echo", map[string]string{"name": "Gopher"})
+//	httpizetest.AssertStatus(t, r, 200)
+//	httpizetest.AssertContentType(t, r, "text/html; charset=utf-8")
+//	if string(r.Body) != "Gopher" {
+//		t.Fatal(...)
+//	}
+package httpizetest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Response is the response to a call.
+type Response struct {
+	Status int
+	Header http.Header
+	// Body is decompressed if the response was gzipped
+	Body []byte
+	// Body was gzipped
+	Gzipped bool
+}
+
+// JSON decodes the body into v.
+func (r *Response) JSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Call makes a GET request of method with args to h, with gzip accepted.
+func Call(h http.Handler, method string, args map[string]string) *Response {
+	return Do(h, newRequest("GET", method, args, nil))
+}
+
+// Post is like Call but makes a POST request with body.
+func Post(h http.Handler, method string, args map[string]string, body io.Reader) *Response {
+	return Do(h, newRequest("POST", method, args, body))
+}
+
+func newRequest(verb, method string, args map[string]string, body io.Reader) *http.Request {
+	q := make(url.Values)
+	for k, v := range args {
+		q.Set(k, v)
+	}
+	u := "http://example.com/" + method
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req := httptest.NewRequest(verb, u, body)
+	req.Header.Set("Accept-Encoding", "gzip")
+	return req
+}
+
+// Do makes req to h and returns the Response.
+func Do(h http.Handler, req *http.Request) *Response {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	r := &Response{Status: recorder.Code, Header: recorder.Header(), Body: recorder.Body.Bytes()}
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		r.Gzipped = true
+		gr, err := gzip.NewReader(bytes.NewReader(r.Body))
+		if err == nil {
+			r.Body, err = io.ReadAll(gr)
+		}
+		if err != nil {
+			panic("httpizetest: invalid gzip response: " + err.Error())
+		}
+	}
+	return r
+}
+
+// AssertStatus fails t if r doesn't have status code status.
+func AssertStatus(t testing.TB, r *Response, status int) {
+	t.Helper()
+	if r.Status != status {
+		t.Fatalf("status %d, want %d: %s", r.Status, status, r.Body)
+	}
+}
+
+// AssertContentType fails t if r doesn't have the Content-Type contentType.
+func AssertContentType(t testing.TB, r *Response, contentType string) {
+	t.Helper()
+	if got := r.Header.Get("Content-Type"); got != contentType {
+		t.Fatalf("Content-Type %q, want %q", got, contentType)
+	}
+}
+
+// AssertGzip fails t if r wasn't gzipped.
+func AssertGzip(t testing.TB, r *Response) {
+	t.Helper()
+	if !r.Gzipped {
+		t.Fatal("response not gzipped")
+	}
+}
+
+// AssertExpires fails t if the Expires header of r isn't about d from now.
+func AssertExpires(t testing.TB, r *Response, d time.Duration) {
+	t.Helper()
+	v := r.Header.Get("Expires")
+	if v == "" {
+		t.Fatal("no Expires header")
+	}
+	e, err := http.ParseTime(v)
+	if err != nil {
+		e, err = time.Parse(time.RFC1123, v)
+	}
+	if err != nil {
+		t.Fatalf("invalid Expires header %q", v)
+	}
+	if diff := time.Until(e) - d; diff < -2*time.Second || diff > 2*time.Second {
+		t.Fatalf("Expires %s, want about %s from now", v, d)
+	}
+}
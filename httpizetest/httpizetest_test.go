@@ -0,0 +1,90 @@
+package httpizetest
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/timob/httpize"
+)
+
+type name string
+
+func (n name) Check() error {
+	return nil
+}
+
+var _ = httpize.AddType("Name", func(s string) httpize.Arg { return name(s) })
+
+var _ = httpize.Handle("/test/Echo?name Name", httpize.Func1(func(n name) (io.WriterTo, *httpize.Settings, error) {
+	s := new(httpize.Settings)
+	s.SetToDefault()
+	s.Gzip = true
+	s.Cache = 60
+	return strings.NewReader(string(n)), s, nil
+}, "name"))
+
+var _ = httpize.Handle("/test/Upper?data Body", httpize.Func1(func(b httpize.Body) (io.WriterTo, *httpize.Settings, error) {
+	data, err := io.ReadAll(b.Reader)
+	s := new(httpize.Settings)
+	s.SetToDefault()
+	s.ContentType = "application/json"
+	return strings.NewReader(`{"data": "` + strings.ToUpper(string(data)) + `"}`), s, err
+}, "data"))
+
+func TestCall(t *testing.T) {
+	h := httpize.GetHandlerForPattern("/test/Echo?name Name")
+	r := Call(h, "Echo", map[string]string{"name": "Gopher"})
+	AssertStatus(t, r, 200)
+	AssertGzip(t, r)
+	AssertExpires(t, r, time.Minute)
+	AssertContentType(t, r, "text/html; charset=utf-8")
+	if string(r.Body) != "Gopher" {
+		t.Fatalf("got %q", r.Body)
+	}
+
+	r = Call(h, "Echo", nil)
+	AssertStatus(t, r, 400)
+}
+
+func TestPost(t *testing.T) {
+	h := httpize.GetHandlerForPattern("/test/Upper?data Body")
+	r := Post(h, "Upper", nil, strings.NewReader("abc"))
+	AssertStatus(t, r, 200)
+	var v struct{ Data string }
+	if err := r.JSON(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Data != "ABC" {
+		t.Fatalf("got %q", v.Data)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	h := httpize.GetHandlerForPattern("/test/Echo?name Name")
+	Golden(t, Call(h, "Echo", map[string]string{"name": "Gopher"}), "echo", "Content-Type", "Content-Encoding")
+}
+
+var stub = new(Stub).Return("first", nil, nil).Return("", nil, httpize.Non500Error{ErrorCode: 404, ErrorStr: "not found"})
+
+var _ = httpize.Handle("/test/Stubbed?name Name", stub)
+
+func TestStub(t *testing.T) {
+	if !Registered("/test/Stubbed") || Registered("/test/Missing") {
+		t.Fatal("Registered wrong")
+	}
+	h := httpize.GetHandlerForPattern("/test/Stubbed?name Name")
+	r := Call(h, "Stubbed", map[string]string{"name": "a"})
+	AssertStatus(t, r, 200)
+	if string(r.Body) != "first" {
+		t.Fatalf("got %q", r.Body)
+	}
+	for range 2 {
+		AssertStatus(t, Call(h, "Stubbed", map[string]string{"name": "b"}), 404)
+	}
+	calls := stub.Calls()
+	if len(calls) != 3 || calls[0]["name"] != name("a") || calls[2]["name"] != name("b") {
+		t.Fatalf("got %v", calls)
+	}
+}
@@ -0,0 +1,69 @@
+package httpizetest
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/timob/httpize"
+)
+
+// Result is a result returned by a Stub.
+type Result struct {
+	Body     []byte
+	Settings *httpize.Settings
+	Err      error
+}
+
+// Stub is a httpize.Caller returning the Results given with Return, in order
+// with the last one repeated, and recording the arguments of calls. It can be
+// used in place of a Caller with real dependencies. The zero value returns an
+// empty body.
+type Stub struct {
+	mu      sync.Mutex
+	results []Result
+	calls   []map[string]httpize.Arg
+}
+
+// Return adds a result to return. Returns s, so calls can be chained.
+func (s *Stub) Return(body string, settings *httpize.Settings, err error) *Stub {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, Result{[]byte(body), settings, err})
+	return s
+}
+
+func (s *Stub) Call(args map[string]httpize.Arg) (io.WriterTo, *httpize.Settings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, args)
+	var r Result
+	if n := len(s.results); n > 0 {
+		r = s.results[0]
+		if n > 1 {
+			s.results = s.results[1:]
+		}
+	}
+	if r.Err != nil {
+		return nil, r.Settings, r.Err
+	}
+	return bytes.NewReader(r.Body), r.Settings, nil
+}
+
+// Calls returns the arguments of the calls made.
+func (s *Stub) Calls() []map[string]httpize.Arg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]httpize.Arg(nil), s.calls...)
+}
+
+// Registered reports whether a method is added at path, as returned by
+// httpize.Methods.
+func Registered(path string) bool {
+	for _, m := range httpize.Methods() {
+		if m.Path == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package httpize
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StoredResponse is a response stored for an Idempotency-Key.
+type StoredResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Hash of the query and body of the request, set for responses stored
+	// for an Idempotency-Key
+	RequestHash string
+}
+
+// IdempotencyStore stores responses of POST requests with an Idempotency-Key
+// header, set it in Options.Idempotency. Requests with the same key get the
+// stored response rather than the method being called again, once they are
+// authenticated and allowed like any other request. Keys are made from the
+// handler path, the name of the Principal of the request and the header
+// value. A request reusing a key with a different query or body gets a 422
+// error. Only 2xx responses are stored.
+type IdempotencyStore interface {
+	Get(key string) (*StoredResponse, bool)
+	Put(key string, r *StoredResponse)
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore keeping responses in memory.
+// Create with NewMemoryIdempotencyStore.
+type MemoryIdempotencyStore struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	responses map[string]storedEntry
+}
+
+type storedEntry struct {
+	r       *StoredResponse
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns a MemoryIdempotencyStore keeping
+// responses for ttl.
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{ttl: ttl, responses: make(map[string]storedEntry)}
+}
+
+func (s *MemoryIdempotencyStore) Get(key string) (*StoredResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.responses[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.r, true
+}
+
+func (s *MemoryIdempotencyStore) Put(key string, r *StoredResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, e := range s.responses {
+		if now.After(e.expires) {
+			delete(s.responses, k)
+		}
+	}
+	s.responses[key] = storedEntry{r, now.Add(s.ttl)}
+}
+
+// keys of requests being handled, so retries while the first request is being
+// handled get a 409 error
+var idempotencyInFlight sync.Map
+
+// idempotent replays the response stored in Options.Idempotency for a POST
+// request with an Idempotency-Key header, returning true if it did or
+// responded with an error. Otherwise it returns resp wrapped to record the
+// response and a func to store it, and req with its body hashed as it is
+// read, so the body is still streamed to the call. Only 2xx responses are
+// stored, so other requests can be retried.
+func (h *handler) idempotent(resp http.ResponseWriter, req *http.Request, start time.Time) (http.ResponseWriter, *http.Request, func(), bool) {
+	var name string
+	if p := PrincipalOf(req); p != nil {
+		name = p.Name()
+	}
+	key := req.URL.Path + "\n" + name + "\n" + req.Header.Get("Idempotency-Key")
+
+	hash := sha256.New()
+	io.WriteString(hash, req.URL.RawQuery+"\n")
+	var body io.Reader
+	if req.Body != nil {
+		body = req.Body
+		if max := h.options.MaxBodySize; max > 0 {
+			body = http.MaxBytesReader(resp, req.Body, max)
+		}
+	}
+
+	store := h.options.Idempotency
+	if r, ok := store.Get(key); ok {
+		if body != nil {
+			if _, err := io.Copy(hash, body); err != nil {
+				h.providerError(err, resp, req, start)
+				return resp, req, nil, true
+			}
+		}
+		if r.RequestHash != hex.EncodeToString(hash.Sum(nil)) {
+			h.providerError(Non500Error{422, "Idempotency-Key used for a different request", ""}, resp, req, start)
+			return resp, req, nil, true
+		}
+		resp.Header().Set("Idempotent-Replayed", "true")
+		writeStored(resp, r)
+		return resp, req, nil, true
+	}
+	if _, loaded := idempotencyInFlight.LoadOrStore(key, true); loaded {
+		h.providerError(Non500Error{409, "request with Idempotency-Key being handled", ""}, resp, req, start)
+		return resp, req, nil, true
+	}
+
+	if body != nil {
+		body = io.TeeReader(body, hash)
+		req = req.WithContext(req.Context())
+		req.Body = readCloser{body, req.Body}
+	}
+	rec := &recordingWriter{ResponseWriter: resp}
+	return rec, req, func() {
+		defer idempotencyInFlight.Delete(key)
+		if rec.code < 200 || rec.code > 299 {
+			return
+		}
+		// hash what the call didn't read
+		if body != nil {
+			if _, err := io.Copy(io.Discard, body); err != nil {
+				return
+			}
+		}
+		store.Put(key, &StoredResponse{StatusCode: rec.code, Header: rec.Header().Clone(), Body: rec.body.Bytes(),
+			RequestHash: hex.EncodeToString(hash.Sum(nil))})
+	}, false
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// recordingWriter keeps a copy of the status code and body written. It has no
+// ReadFrom as the body has to be copied anyway.
+type recordingWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
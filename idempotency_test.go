@@ -0,0 +1,145 @@
+package httpize
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var payments int
+
+var _ = HandleWithOptions("/Pay", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	payments++
+	return strings.NewReader("payment " + strconv.Itoa(payments)), NewSettings().WithStatusCode(201), nil
+}), &Options{Idempotency: payStore})
+
+var payStore = NewMemoryIdempotencyStore(time.Minute)
+
+func TestIdempotencyKey(t *testing.T) {
+	h := GetHandlerForPattern("/Pay")
+	payments = 0
+	payStore.responses = make(map[string]storedEntry)
+
+	pay := func(key string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "http://host/Pay", strings.NewReader("amount=1"))
+		if key != "" {
+			request.Header.Set("Idempotency-Key", key)
+		}
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := pay("a")
+	checkCode(t, first, 201)
+	retry := pay("a")
+	checkCode(t, retry, 201)
+	if retry.Body.String() != "payment 1" || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry not replayed: %s", retry.Body.String())
+	}
+	if other := pay("b"); other.Body.String() != "payment 2" {
+		t.Fatalf("got %s", other.Body.String())
+	}
+	if none := pay(""); none.Body.String() != "payment 3" {
+		t.Fatalf("got %s", none.Body.String())
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "http://host/Pay", strings.NewReader("amount=2"))
+	request.Header.Set("Idempotency-Key", "a")
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 422)
+}
+
+var authPayments int
+
+var _ = HandleWithOptions("/Auth/Pay", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	authPayments++
+	if authPayments == 1 {
+		return nil, nil, Non500Error{400, "declined", ""}
+	}
+	return strings.NewReader("payment " + strconv.Itoa(authPayments)), NewSettings().WithStatusCode(201), nil
+}), &Options{Authenticator: testAuthenticator{}, RequireAuth: true, Idempotency: authPayStore})
+
+var authPayStore = NewMemoryIdempotencyStore(time.Minute)
+
+func TestIdempotencyKeyScope(t *testing.T) {
+	h := GetHandlerForPattern("/Auth/Pay")
+	authPayments = 0
+	authPayStore.responses = make(map[string]storedEntry)
+
+	pay := func(user string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "http://host/Auth/Pay", nil)
+		request.Header.Set("Idempotency-Key", "k")
+		request.Header.Set("X-User", user)
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// errors are not stored
+	checkCode(t, pay("alice"), 400)
+	checkCode(t, pay("alice"), 201)
+	if r := pay("alice"); r.Body.String() != "payment 2" || r.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("not replayed: %s", r.Body.String())
+	}
+	if r := pay("bob"); r.Body.String() != "payment 3" {
+		t.Fatalf("replayed for another user: %s", r.Body.String())
+	}
+	checkCode(t, pay(""), 401)
+}
+
+var uploadRead = make(chan bool, 1)
+
+var _ = HandleWithOptions("/Idempotent/Store?data Body", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	data := args["data"].(Body)
+	first := make([]byte, 5)
+	if _, err := io.ReadFull(data, first); err != nil {
+		return nil, nil, err
+	}
+	uploadRead <- true
+	rest, err := io.ReadAll(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return strings.NewReader(string(first) + string(rest)), nil, nil
+}), &Options{Idempotency: NewMemoryIdempotencyStore(time.Minute)})
+
+func TestIdempotencyKeyStreamed(t *testing.T) {
+	h := GetHandlerForPattern("/Idempotent/Store?data Body")
+	key := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	upload := func(body io.Reader) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "http://host/Idempotent/Store", body)
+		request.Header.Set("Idempotency-Key", key)
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// the call reads the start of the body before the rest is sent
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("hello"))
+		select {
+		case <-uploadRead:
+		case <-time.After(2 * time.Second):
+			pw.CloseWithError(errors.New("body buffered before the call"))
+			return
+		}
+		pw.Write([]byte(" world"))
+		pw.Close()
+	}()
+	if r := upload(pr); r.Body.String() != "hello world" {
+		t.Fatalf("got %q", r.Body.String())
+	}
+	if r := upload(strings.NewReader("hello world")); r.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("not replayed")
+	}
+	checkCode(t, upload(strings.NewReader("hello there")), 422)
+}
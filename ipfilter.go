@@ -0,0 +1,118 @@
+package httpize
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPFilter allows or denies requests by client IP address, requests that are
+// not allowed get a 403 error. Set it in Options.IPFilter for all handlers
+// using the Options, or use WithIPFilter for one method. Create with
+// NewIPFilter.
+type IPFilter struct {
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	trusted []netip.Prefix
+}
+
+// NewIPFilter returns an IPFilter. allow, deny and trusted are lists of IP
+// addresses or CIDR prefixes like "10.0.0.0/8". If allow is not empty only
+// clients in it are allowed. Clients in deny are not allowed. If the request
+// is from a proxy in trusted the client is taken from X-Forwarded-For, as the
+// last address in it that is not a trusted proxy, or is the proxy if there is
+// no X-Forwarded-For.
+func NewIPFilter(allow, deny, trusted []string) (*IPFilter, error) {
+	f := new(IPFilter)
+	for _, l := range []struct {
+		s []string
+		p *[]netip.Prefix
+	}{{allow, &f.allow}, {deny, &f.deny}, {trusted, &f.trusted}} {
+		for _, s := range l.s {
+			p, err := parsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			*l.p = append(*l.p, p)
+		}
+	}
+	return f, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+func contains(l []netip.Prefix, a netip.Addr) bool {
+	for _, p := range l {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// client returns the client address of req.
+func (f *IPFilter) client(req *http.Request) (netip.Addr, error) {
+	ap, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, errors.New("invalid remote address " + req.RemoteAddr)
+	}
+	a := ap.Addr().Unmap()
+	if !contains(f.trusted, a) {
+		return a, nil
+	}
+	xff := strings.Join(req.Header.Values("X-Forwarded-For"), ",")
+	if xff == "" {
+		return a, nil
+	}
+	hops := strings.Split(xff, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, errors.New("invalid X-Forwarded-For")
+		}
+		a = hop.Unmap()
+		if !contains(f.trusted, a) {
+			break
+		}
+	}
+	return a, nil
+}
+
+type clientAddrKey struct{}
+
+// check returns a 403 error if the client of req is not allowed. Returns req
+// with the client address in its context for ClientIP.
+func (f *IPFilter) check(req *http.Request) (*http.Request, error) {
+	a, err := f.client(req)
+	if err != nil {
+		return req, Non500Error{403, err.Error(), ""}
+	}
+	if contains(f.deny, a) || (len(f.allow) > 0 && !contains(f.allow, a)) {
+		return req, Non500Error{403, "client address not allowed", ""}
+	}
+	return req.WithContext(context.WithValue(req.Context(), clientAddrKey{}, a)), nil
+}
+
+// WithIPFilter returns a Caller for c to be given to Handle, requests to the
+// handler must be allowed by f, as well as Options.IPFilter. Optional
+// interfaces c implements are still used by the handler.
+func WithIPFilter(c Caller, f *IPFilter) Caller {
+	return ipFilterCaller{c, f}
+}
+
+type ipFilterCaller struct {
+	Caller
+	filter *IPFilter
+}
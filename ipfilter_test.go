@@ -0,0 +1,49 @@
+package httpize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var internalOnly, _ = NewIPFilter([]string{"10.0.0.0/8", "::1"}, []string{"10.0.0.13"}, []string{"192.168.0.1"})
+
+var _ = Handle("/Admin/Reset", WithIPFilter(CallerFunc(NilSettings), internalOnly))
+
+func TestIPFilter(t *testing.T) {
+	h := GetHandlerForPattern("/Admin/Reset")
+
+	for _, c := range []struct {
+		remote, forwarded string
+		code              int
+	}{
+		{"10.1.2.3:1234", "", 200},
+		{"[::1]:1234", "", 200},
+		{"10.0.0.13:1234", "", 403},
+		{"8.8.8.8:1234", "", 403},
+		{"8.8.8.8:1234", "10.1.2.3", 403},
+		{"192.168.0.1:1234", "8.8.8.8, 10.1.2.3", 200},
+		{"192.168.0.1:1234", "10.1.2.3, 8.8.8.8", 403},
+		{"192.168.0.1:1234", "", 403},
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/Admin/Reset", nil)
+		request.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			request.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		h.ServeHTTP(recorder, request)
+		if recorder.Code != c.code {
+			t.Fatalf("%s %s: got %d", c.remote, c.forwarded, recorder.Code)
+		}
+	}
+}
+
+func TestIPFilterNoForwarded(t *testing.T) {
+	request, _ := http.NewRequest("GET", "http://host/Admin/Reset", nil)
+	request.RemoteAddr = "192.168.0.1:1234"
+	a, err := internalOnly.client(request)
+	if err != nil || a.String() != "192.168.0.1" {
+		t.Fatalf("got %v %v", a, err)
+	}
+}
@@ -0,0 +1,254 @@
+package httpize
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWTClaims are the claims of a verified JSON Web Token. It is the Principal
+// of requests authenticated by JWTAuth.
+type JWTClaims map[string]interface{}
+
+// Name returns the sub claim.
+func (c JWTClaims) Name() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+// HasRole reports whether role is in the space separated scope claim or the
+// roles claim array.
+func (c JWTClaims) HasRole(role string) bool {
+	if s, ok := c["scope"].(string); ok {
+		for _, r := range strings.Fields(s) {
+			if r == role {
+				return true
+			}
+		}
+	}
+	roles, _ := c["roles"].([]interface{})
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// JWTAuth is an Authenticator for JSON Web Tokens given as
+// "Authorization: Bearer" tokens. Tokens signed with HS256, HS384, HS512,
+// RS256, RS384, RS512, ES256, ES384 or ES512 are supported, ES keys must be
+// on the curve of the algorithm. Expired or invalid tokens, or tokens with an
+// exp or nbf claim that isn't a number, get a 401 error.
+type JWTAuth struct {
+	// Returns the key to verify a token signed with alg, kid is the key ID
+	// from the token header and can be "". The key is a []byte for HS
+	// algorithms, a *rsa.PublicKey for RS and a *ecdsa.PublicKey for ES.
+	// JWKS can be used to get keys from a JSON Web Key Set.
+	Key func(alg, kid string) (interface{}, error)
+	// If not "" the iss claim must be this
+	Issuer string
+	// If not "" the aud claim must be or contain this
+	Audience string
+	// Allowed clock skew when checking exp and nbf
+	Leeway time.Duration
+}
+
+func (a *JWTAuth) Challenge() string {
+	return "Bearer"
+}
+
+func (a *JWTAuth) Authenticate(req *http.Request) (Principal, error) {
+	auth := req.Header.Get("Authorization")
+	if auth == "" {
+		return nil, nil
+	}
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return nil, errors.New("not a bearer token")
+	}
+	claims, err := a.verify(strings.TrimSpace(auth[7:]))
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// bit sizes of the curves of the ES algorithms
+var jwtCurves = map[string]int{
+	"ES256": 256,
+	"ES384": 384,
+	"ES512": 521,
+}
+
+// verify checks the signature and claims of token.
+func (a *JWTAuth) verify(token string) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	if len(header.Alg) != 5 {
+		return nil, errors.New("unsupported alg " + header.Alg)
+	}
+	hash, ok := jwtHashes[header.Alg[2:]]
+	if !ok {
+		return nil, errors.New("unsupported alg " + header.Alg)
+	}
+	key, err := a.Key(header.Alg, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+
+	valid := false
+	switch k := key.(type) {
+	case []byte:
+		if header.Alg[:2] == "HS" {
+			mac := hmac.New(hash.New, k)
+			mac.Write([]byte(parts[0] + "." + parts[1]))
+			valid = hmac.Equal(sig, mac.Sum(nil))
+		}
+	case *rsa.PublicKey:
+		if header.Alg[:2] == "RS" {
+			valid = rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if jwtCurves[header.Alg] == k.Curve.Params().BitSize && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			valid = ecdsa.Verify(k, digest, r, s)
+		}
+	}
+	if !valid {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims JWTClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"exp", "nbf"} {
+		if v, ok := claims[name]; ok {
+			if _, ok := v.(float64); !ok {
+				return nil, errors.New("invalid token " + name + " claim")
+			}
+		}
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(a.Leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-a.Leeway)) {
+		return nil, errors.New("token not valid yet")
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return nil, errors.New("invalid token issuer")
+	}
+	if a.Audience != "" && !claims.hasAudience(a.Audience) {
+		return nil, errors.New("invalid token audience")
+	}
+	return claims, nil
+}
+
+func (c JWTClaims) hasAudience(aud string) bool {
+	switch v := c["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// JWKS returns a func that can be used as JWTAuth.Key, with the RSA and EC
+// keys of a JSON Web Key Set.
+func JWKS(data []byte) (func(alg, kid string) (interface{}, error), error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				return nil, errors.New("httpize: invalid JWK " + k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+			curve, ok := curves[k.Crv]
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if !ok || err1 != nil || err2 != nil {
+				return nil, errors.New("httpize: invalid JWK " + k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	return func(alg, kid string) (interface{}, error) {
+		k, ok := keys[kid]
+		if !ok {
+			return nil, errors.New("unknown key " + kid)
+		}
+		return k, nil
+	}, nil
+}
@@ -0,0 +1,87 @@
+package httpize
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var jwtSecret = []byte("secret")
+
+func signHS256(claims string) string {
+	s := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(s))
+	return s + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+var _ = HandleWithOptions("/JWT/Whoami", WithRoles(CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, nil
+}), "read"), &Options{
+	Authenticator: &JWTAuth{
+		Key:      func(alg, kid string) (interface{}, error) { return jwtSecret, nil },
+		Audience: "api",
+	},
+	Settings: &Settings{StatusCode: 204},
+})
+
+func TestJWTAuth(t *testing.T) {
+	h := GetHandlerForPattern("/JWT/Whoami")
+	exp := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	for token, code := range map[string]int{
+		signHS256(`{"sub":"alice","aud":"api","scope":"read write","exp":` + exp + `}`):                     204,
+		signHS256(`{"sub":"alice","aud":"api","scope":"write","exp":` + exp + `}`):                          403,
+		signHS256(`{"sub":"alice","aud":"other","scope":"read"}`):                                           401,
+		signHS256(`{"sub":"alice","aud":"api","scope":"read","exp":1}`):                                     401,
+		signHS256(`{"sub":"alice","aud":"api","scope":"read","exp":"` + exp + `"}`):                         401,
+		signHS256(`{"sub":"alice","aud":"api","scope":"read","nbf":null}`):                                  401,
+		strings.Replace(signHS256(`{"sub":"alice","aud":"api","scope":"read"}`), "eyJzdWIi", "eyJzdWJi", 1): 401,
+		"": 401,
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/JWT/Whoami", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, code)
+		if code == 401 && recorder.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Fatal("WWW-Authenticate not set")
+		}
+	}
+}
+
+func TestJWTCurve(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	sign := func(alg string) string {
+		s := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"`+alg+`"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`))
+		h := jwtHashes[alg[2:]].New()
+		h.Write([]byte(s))
+		r, ss, _ := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+		sig := make([]byte, 96)
+		r.FillBytes(sig[:48])
+		ss.FillBytes(sig[48:])
+		return s + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+	a := &JWTAuth{Key: func(alg, kid string) (interface{}, error) { return &key.PublicKey, nil }}
+
+	if _, err := a.verify(sign("ES384")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.verify(sign("ES256")); err == nil {
+		t.Fatal("ES256 token verified with a P-384 key")
+	}
+}
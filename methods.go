@@ -0,0 +1,144 @@
+package httpize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// MethodInfo describes a method added with Handle.
+type MethodInfo struct {
+	Name string `json:"name"`
+	// URL path of the method
+	Path string `json:"path"`
+	// HTTP methods the method can be called with
+	Verbs []string  `json:"verbs"`
+	Args  []ArgInfo `json:"args"`
+	// Content type of responses unless the Caller returns Settings with
+	// another
+	ContentType string `json:"content_type"`
+	// Requests must be authenticated
+	Authenticated bool `json:"authenticated"`
+	// Example curl command, set by HandleMethods and HandleDocs
+	Curl string `json:"curl,omitempty"`
+}
+
+// ArgInfo describes an argument of a method.
+type ArgInfo struct {
+	Name string `json:"name"`
+	// Type as in the handler pattern, a type added with AddType, Body,
+	// Principal or Session
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	Default  string `json:"default,omitempty"`
+	// JSON Schema of the values accepted, if given with AddTypeSchema
+	Schema map[string]interface{} `json:"schema,omitempty"`
+}
+
+// Methods returns descriptions of the methods added, sorted by path.
+func Methods() []MethodInfo {
+	var m []MethodInfo
+	for _, h := range handlers {
+		m = append(m, h.(*handler).info())
+	}
+	sort.Slice(m, func(i, j int) bool {
+		return m[i].Path < m[j].Path
+	})
+	return m
+}
+
+func (h *handler) info() MethodInfo {
+	m := MethodInfo{
+		Name:  h.name,
+		Path:  h.path,
+		Verbs: []string{"GET", "POST"},
+		Args:  make([]ArgInfo, len(h.argBuilders)),
+	}
+	m.Authenticated = len(h.roles) > 0 || h.options.RequireAuth
+	for i, b := range h.argBuilders {
+		m.Args[i] = ArgInfo{b.key, b.typ, b.required, b.def, b.schema}
+		if b.principal && b.required {
+			m.Authenticated = true
+		}
+	}
+
+	s := h.settings
+	if s == nil {
+		s = h.options.Settings
+	}
+	if s == nil {
+		s = h.defaultSettings
+	}
+	m.ContentType = s.ContentType
+	if e, ok := lookupEncoder(s.Encode); ok && s.Encode != "" {
+		m.ContentType = e.contentType
+	}
+	return m
+}
+
+// HandleMethods adds a handler at path/_methods responding with Methods as
+// JSON. path is "" or like "/api". Always returns true.
+func HandleMethods(path string) bool {
+	http.HandleFunc(path+"/_methods", func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "application/json")
+		methods := Methods()
+		base := baseURL(req)
+		for i := range methods {
+			methods[i].Curl = methods[i].CurlCommand(base)
+		}
+		json.NewEncoder(resp).Encode(methods)
+	})
+	return true
+}
+
+// exampleURL returns the URL path and query of a call of m, with arguments
+// given their default or their name in angle brackets.
+func (m MethodInfo) exampleURL() string {
+	var q []string
+	for _, a := range m.Args {
+		if a.Type == "Body" || a.Type == "Principal" || a.Type == "Session" {
+			continue
+		}
+		v := url.QueryEscape(a.Default)
+		if v == "" {
+			v = "<" + a.Name + ">"
+		}
+		q = append(q, url.QueryEscape(a.Name)+"="+v)
+	}
+	if len(q) == 0 {
+		return m.Path
+	}
+	return m.Path + "?" + strings.Join(q, "&")
+}
+
+// CurlCommand returns a curl command calling m at base, like
+// "https://example.com". Arguments are given their default or their name in
+// angle brackets, as are the request body and credentials.
+func (m MethodInfo) CurlCommand(base string) string {
+	c := "curl"
+	if m.Authenticated {
+		c += " -H 'Authorization: <credentials>'"
+	}
+	for _, a := range m.Args {
+		if a.Type == "Body" {
+			c += " --data-binary @<" + a.Name + "-file>"
+			break
+		}
+	}
+	return c + " " + shellQuote(base+m.exampleURL())
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// baseURL returns the scheme and host req was made to.
+func baseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host
+}
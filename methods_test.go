@@ -0,0 +1,63 @@
+package httpize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var _ = HandleMethods("/api")
+
+func TestMethods(t *testing.T) {
+	var upload *MethodInfo
+	methods := Methods()
+	for i := range methods {
+		if i > 0 && methods[i-1].Path > methods[i].Path {
+			t.Fatal("methods not sorted")
+		}
+		if methods[i].Path == "/Upload" {
+			upload = &methods[i]
+		}
+	}
+	if upload == nil {
+		t.Fatal("/Upload not found")
+	}
+	want := []ArgInfo{{"name", "SafeString", true, "", nil}, {"data", "Body", true, "", nil}}
+	if upload.Name != "Upload" || !reflect.DeepEqual(upload.Args, want) {
+		t.Fatalf("got %+v", upload)
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/api/_methods", nil)
+	http.DefaultServeMux.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	var got []MethodInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(methods) {
+		t.Fatalf("got %d methods, want %d", len(got), len(methods))
+	}
+	for _, m := range got {
+		if m.Path == "/Upload" && m.Curl != "curl --data-binary @<data-file> 'http://host/Upload?name=<name>'" {
+			t.Errorf("got %s", m.Curl)
+		}
+	}
+}
+
+func TestCurlCommand(t *testing.T) {
+	m := MethodInfo{
+		Path:          "/a/Page",
+		Args:          []ArgInfo{{"q", "SafeString", true, "", nil}, {"page", "SafeString", false, "1", nil}, {"who", "Principal", true, "", nil}},
+		Authenticated: true,
+	}
+	want := "curl -H 'Authorization: <credentials>' 'https://example.com/a/Page?q=<q>&page=1'"
+	if got := m.CurlCommand("https://example.com"); got != want {
+		t.Errorf("got %s", got)
+	}
+	if got := shellQuote("it's"); got != `'it'\''s'` {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,53 @@
+package httpize
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RequestMetrics are the metrics of a request to a method.
+type RequestMetrics struct {
+	Method   string
+	Status   int
+	Duration time.Duration
+	// Bytes of the response body written, after any compression
+	ResponseSize int64
+}
+
+// StatusClass returns the class of the status code, like "2xx".
+func (m RequestMetrics) StatusClass() string {
+	return strconv.Itoa(m.Status/100) + "xx"
+}
+
+// MetricsSink is given the RequestMetrics of each request once the response
+// has been written. Set it in Options.Metrics. It can be used to update
+// Prometheus histograms and counters labeled by method name, without httpize
+// depending on a metrics library.
+type MetricsSink interface {
+	Observe(m RequestMetrics)
+}
+
+// MetricsFunc is a MetricsSink calling itself.
+type MetricsFunc func(m RequestMetrics)
+
+func (f MetricsFunc) Observe(m RequestMetrics) {
+	f(m)
+}
+
+// metrics wraps next to give the RequestMetrics of requests to
+// Options.Metrics.
+func (h *handler) metrics(next http.Handler) http.Handler {
+	sink := h.options.Metrics
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: resp}
+		defer func() {
+			if sw.code == 0 {
+				sw.code = http.StatusOK
+			}
+			sink.Observe(RequestMetrics{h.name, sw.code, time.Since(start), sw.size})
+		}()
+		next.ServeHTTP(sw, req)
+	})
+}
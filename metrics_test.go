@@ -0,0 +1,34 @@
+package httpize
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var observed []RequestMetrics
+
+var _ = HandleWithOptions("/Measured", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return strings.NewReader("hello"), nil, nil
+}), &Options{Metrics: MetricsFunc(func(m RequestMetrics) { observed = append(observed, m) })})
+
+func TestMetrics(t *testing.T) {
+	observed = nil
+	h := GetHandlerForPattern("/Measured")
+	for _, url := range []string{"http://host/Measured", "http://host/Measured?x=1"} {
+		request, _ := http.NewRequest("GET", url, nil)
+		h.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	if len(observed) != 2 {
+		t.Fatalf("got %d metrics", len(observed))
+	}
+	if m := observed[0]; m.Method != "Measured" || m.Status != 200 || m.ResponseSize != 5 || m.StatusClass() != "2xx" || m.Duration <= 0 {
+		t.Errorf("got %+v", m)
+	}
+	if m := observed[1]; m.Status != 400 || m.StatusClass() != "4xx" {
+		t.Errorf("got %+v", m)
+	}
+}
@@ -2,9 +2,19 @@ package httpize
 
 import (
 	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -62,29 +72,29 @@ func TestTestApiProvider(t *testing.T) {
 	if recorder.Body.String() != "Echo Gopher" {
 		t.Fatal("incorrect response")
 	}
-	if v, ok := recorder.HeaderMap["Content-Type"]; !ok || v[0] != "text/html" {
+	if v, ok := recorder.HeaderMap["Content-Type"]; !ok || v[0] != "text/html; charset=utf-8" {
 		t.Fatalf("Content-Type header missing or invalid")
 	}
 
 	recorder = httptest.NewRecorder()
 	request, _ = http.NewRequest("GET", "http://host/Echo?badparam=Gopher", nil)
 	h.ServeHTTP(recorder, request)
-	checkCode(t, recorder, 500)
+	checkCode(t, recorder, 400)
 
 	recorder = httptest.NewRecorder()
 	request, _ = http.NewRequest("GET", "http://host/Echo?name=Go'pher", nil)
 	h.ServeHTTP(recorder, request)
-	checkCode(t, recorder, 500)
+	checkCode(t, recorder, 400)
 
 	recorder = httptest.NewRecorder()
 	request, _ = http.NewRequest("GET", "http://host/Echo?name=Gopher&name=Gopher", nil)
 	h.ServeHTTP(recorder, request)
-	checkCode(t, recorder, 500)
+	checkCode(t, recorder, 400)
 
 	recorder = httptest.NewRecorder()
 	request, _ = http.NewRequest("GET", "http://host/Echo?name=Gopher&badparam=Gopher", nil)
 	h.ServeHTTP(recorder, request)
-	checkCode(t, recorder, 500)
+	checkCode(t, recorder, 400)
 
 	h = GetHandlerForPattern("/Greeting")
 
@@ -115,6 +125,9 @@ func TestTestApiProvider(t *testing.T) {
 	if err != nil || cacheTime.Before(now) {
 		t.Fatalf("Expires header invalid")
 	}
+	if v := recorder.Header().Get("Cache-Control"); v != "max-age=300" {
+		t.Fatalf("Cache-Control header invalid: %s", v)
+	}
 
 	settings.SetToDefault()
 	settings.Gzip = true
@@ -138,3 +151,1158 @@ func TestTestApiProvider(t *testing.T) {
 		t.Fatalf("Unexpected Content-Encoding")
 	}
 }
+
+// A Caller that checks its arguments together
+type RangeFunc CommonFunc
+
+func (f RangeFunc) Call(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return CommonFunc(f).Call(args)
+}
+
+func (f RangeFunc) ValidateCall(methodName string, args map[string]Arg) error {
+	if args["from"].(SafeString) > args["to"].(SafeString) {
+		return Non500Error{400, methodName + ": from must be before to", ""}
+	}
+	return nil
+}
+
+func Range(args map[string]Arg) (io.WriterTo, error) {
+	from, to := args["from"].(SafeString), args["to"].(SafeString)
+	return bytes.NewBufferString(string(from) + "-" + string(to)), nil
+}
+
+var _ = Handle("/Range?from SafeString&to SafeString", RangeFunc(Range))
+
+func TestValidateCall(t *testing.T) {
+	settings.SetToDefault()
+	h := GetHandlerForPattern("/Range?from SafeString&to SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Range?from=a&to=b", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != "a-b" {
+		t.Fatal("incorrect response")
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Range?from=b&to=a", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 400)
+}
+
+var _ = HandleWithOptions("/Lenient/Echo?name SafeString", CommonFunc(Echo), &Options{IgnoreUnknown: true})
+
+func TestIgnoreUnknown(t *testing.T) {
+	settings.SetToDefault()
+	h := GetHandlerForPattern("/Lenient/Echo?name SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Lenient/Echo?name=Gopher&utm_source=x", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != "Echo Gopher" {
+		t.Fatal("incorrect response")
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Lenient/Echo?utm_source=x", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 400)
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Lenient/Echo?name=Gopher&name=Gopher", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 400)
+}
+
+var _ = HandleWithOptions("/First/Echo?name SafeString", CommonFunc(Echo), &Options{Duplicates: DuplicateFirst})
+var _ = HandleWithOptions("/Last/Echo?name SafeString", CommonFunc(Echo), &Options{Duplicates: DuplicateLast})
+
+func TestDuplicates(t *testing.T) {
+	settings.SetToDefault()
+	h := GetHandlerForPattern("/First/Echo?name SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/First/Echo?name=Gopher&name=Gordon", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != "Echo Gopher" {
+		t.Fatal("incorrect response")
+	}
+
+	h = GetHandlerForPattern("/Last/Echo?name SafeString")
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Last/Echo?name=Gopher&name=Gordon", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != "Echo Gordon" {
+		t.Fatal("incorrect response")
+	}
+
+	h = GetHandlerForPattern("/Echo?name SafeString")
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Echo?name=Gopher&name=Gordon", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 400)
+	if !strings.Contains(recorder.Body.String(), "name") {
+		t.Fatal("error does not name parameter")
+	}
+}
+
+func Upload(args map[string]Arg) (io.WriterTo, error) {
+	buf := new(bytes.Buffer)
+	_, err := buf.ReadFrom(args["data"].(Body))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBufferString(string(args["name"].(SafeString)) + ": " + buf.String()), nil
+}
+
+var _ = HandleWithOptions("/Upload?name SafeString&data Body", CommonFunc(Upload), &Options{MaxBodySize: 8, MaxQueryLength: 20})
+
+func TestBody(t *testing.T) {
+	settings.SetToDefault()
+	h := GetHandlerForPattern("/Upload?name SafeString&data Body")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "http://host/Upload?name=file", strings.NewReader("contents"))
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != "file: contents" {
+		t.Fatal("incorrect response")
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "http://host/Upload?name=file", strings.NewReader("too much contents"))
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 413)
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "http://host/Upload?name=file", io.MultiReader(strings.NewReader("too much contents")))
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 413)
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "http://host/Upload?name=file&data=x", strings.NewReader("contents"))
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 400)
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "http://host/Upload?name=a_very_long_file_name", strings.NewReader("contents"))
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 414)
+}
+
+func TestCompile(t *testing.T) {
+	h := GetHandlerForPattern("/Upload?name SafeString&data Body").(*handler)
+	if !h.queryArgs["name"] || h.queryArgs["data"] || len(h.queryArgs) != 1 {
+		t.Fatalf("queryArgs = %v", h.queryArgs)
+	}
+	if h.dispatch == nil {
+		t.Fatal("dispatch not set")
+	}
+}
+
+func Page(args map[string]Arg) (io.WriterTo, error) {
+	s := string(args["q"].(SafeString)) + " " + string(args["page"].(SafeString))
+	if sort, ok := args["sort"]; ok {
+		s += " " + string(sort.(SafeString))
+	}
+	return bytes.NewBufferString(s), nil
+}
+
+var _ = Handle("/Page?q SafeString&page SafeString=1&sort SafeString=", CommonFunc(Page))
+
+var _ = HandleArgs("/ArgsPage", CommonFunc(Page), []ArgDef{
+	{Key: "q", Type: "SafeString", Required: true},
+	{Key: "page", Type: "SafeString", Default: "1"},
+	{Key: "sort", Type: "SafeString"},
+}, nil)
+
+func TestDefaults(t *testing.T) {
+	settings.SetToDefault()
+	for _, p := range []string{"/Page?q SafeString&page SafeString=1&sort SafeString=", "/ArgsPage"} {
+		h := GetHandlerForPattern(p)
+		base := "http://host" + strings.Split(p, "?")[0]
+
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", base+"?q=go", nil)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 200)
+		if recorder.Body.String() != "go 1" {
+			t.Fatal("incorrect response")
+		}
+
+		recorder = httptest.NewRecorder()
+		request, _ = http.NewRequest("GET", base+"?q=go&page=2&sort=date", nil)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 200)
+		if recorder.Body.String() != "go 2 date" {
+			t.Fatal("incorrect response")
+		}
+
+		recorder = httptest.NewRecorder()
+		request, _ = http.NewRequest("GET", base+"?page=2", nil)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 400)
+	}
+}
+
+var jsonSettings = &Settings{Encode: "json"}
+
+type ValueFunc func(map[string]Arg) (interface{}, error)
+
+func (f ValueFunc) Call(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	v, err := f(args)
+	return Value(v), jsonSettings, err
+}
+
+func EchoValue(args map[string]Arg) (interface{}, error) {
+	if args["name"].(SafeString) == "chan" {
+		return make(chan int), nil
+	}
+	return map[string]string{"name": string(args["name"].(SafeString))}, nil
+}
+
+var _ = Handle("/EchoValue?name SafeString", ValueFunc(EchoValue))
+
+func TestEncodeJSON(t *testing.T) {
+	h := GetHandlerForPattern("/EchoValue?name SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/EchoValue?name=Gopher", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != "{\"name\":\"Gopher\"}\n" {
+		t.Fatal("incorrect response")
+	}
+	if v := recorder.Header().Get("Content-Type"); v != "application/json" {
+		t.Fatalf("Content-Type header invalid: %s", v)
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/EchoValue?name=chan", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 500)
+}
+
+// A Caller that sets its own Settings
+type CallerFunc func(map[string]Arg) (io.WriterTo, *Settings, error)
+
+func (f CallerFunc) Call(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return f(args)
+}
+
+type Item struct {
+	Name string `xml:"name"`
+}
+
+func XMLItems(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return Value([]Item{{"a"}, {"b"}}), &Settings{Encode: "xml", XMLRoot: "items"}, nil
+}
+
+var _ = Handle("/XMLItems", CallerFunc(XMLItems))
+
+func TestEncodeXML(t *testing.T) {
+	h := GetHandlerForPattern("/XMLItems")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/XMLItems", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != xml.Header+"<items><Item><name>a</name></Item><Item><name>b</name></Item></items>" {
+		t.Fatal("incorrect response")
+	}
+	if v := recorder.Header().Get("Content-Type"); v != "application/xml" {
+		t.Fatalf("Content-Type header invalid: %s", v)
+	}
+}
+
+var _ = RegisterEncoder("text/csv", func(w io.Writer, v interface{}) error {
+	_, err := io.WriteString(w, strings.Join(v.([]string), ",")+"\n")
+	return err
+})
+
+func CSVRow(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return Value([]string{"a", "b"}), &Settings{Encode: "text/csv"}, nil
+}
+
+var _ = Handle("/CSVRow", CallerFunc(CSVRow))
+
+func TestRegisterEncoder(t *testing.T) {
+	h := GetHandlerForPattern("/CSVRow")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/CSVRow", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != "a,b\n" {
+		t.Fatal("incorrect response")
+	}
+	if v := recorder.Header().Get("Content-Type"); v != "text/csv" {
+		t.Fatalf("Content-Type header invalid: %s", v)
+	}
+}
+
+func NegotiateItem(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return Value(Item{"a"}), &Settings{Negotiate: []string{"json", "xml"}}, nil
+}
+
+var _ = Handle("/NegotiateItem", CallerFunc(NegotiateItem))
+
+func TestNegotiate(t *testing.T) {
+	h := GetHandlerForPattern("/NegotiateItem")
+
+	for accept, contentType := range map[string]string{
+		"":                "application/json",
+		"application/xml": "application/xml",
+		"application/json;q=0.5, text/xml, application/*;q=0.8": "application/xml",
+		"*/*":                              "application/json",
+		"text/html, application/xml;q=0.1": "application/xml",
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/NegotiateItem", nil)
+		request.Header.Set("Accept", accept)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 200)
+		if v := recorder.Header().Get("Content-Type"); v != contentType {
+			t.Fatalf("Accept %s: Content-Type header invalid: %s", accept, v)
+		}
+		if recorder.Header().Get("Vary") != "Accept" {
+			t.Fatal("Vary header missing")
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/NegotiateItem", nil)
+	request.Header.Set("Accept", "text/html, application/json;q=0")
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 406)
+}
+
+var templateOptions = &Options{
+	Templates: template.Must(template.New("greet").Parse("<p>Hello {{.}}</p>")),
+}
+
+func TemplateGreet(args map[string]Arg) (io.WriterTo, error) {
+	name := string(args["name"].(SafeString))
+	if name == "missing" {
+		return TemplateResult{"missing", nil}, nil
+	}
+	return TemplateResult{"greet", name}, nil
+}
+
+var _ = HandleWithOptions("/TemplateGreet?name SafeString", CommonFunc(TemplateGreet), templateOptions)
+
+func TestTemplateResult(t *testing.T) {
+	settings.SetToDefault()
+	h := GetHandlerForPattern("/TemplateGreet?name SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/TemplateGreet?name=<Gopher>", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != "<p>Hello &lt;Gopher&gt;</p>" {
+		t.Fatal("incorrect response")
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/TemplateGreet?name=missing", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 500)
+}
+
+func Create(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	s := &Settings{StatusCode: 201, Headers: http.Header{}}
+	s.Headers.Set("Location", "/Thing?id=1")
+	return bytes.NewBufferString("created"), s, nil
+}
+
+var _ = Handle("/Create", CallerFunc(Create))
+
+func TestStatusCode(t *testing.T) {
+	h := GetHandlerForPattern("/Create")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "http://host/Create", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 201)
+	if recorder.Body.String() != "created" {
+		t.Fatal("incorrect response")
+	}
+	if recorder.Header().Get("Location") != "/Thing?id=1" {
+		t.Fatal("Location header missing")
+	}
+}
+
+func Moved(args map[string]Arg) (io.WriterTo, error) {
+	return Redirect(301, "/Greeting"), nil
+}
+
+var _ = Handle("/Moved", CommonFunc(Moved))
+
+func TestRedirect(t *testing.T) {
+	settings.SetToDefault()
+	h := GetHandlerForPattern("/Moved")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Moved", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 301)
+	if recorder.Header().Get("Location") != "/Greeting" {
+		t.Fatal("Location header missing")
+	}
+	if !strings.Contains(recorder.Body.String(), "/Greeting") {
+		t.Fatal("incorrect response")
+	}
+}
+
+func Delete(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, &Settings{StatusCode: 204}, nil
+}
+
+var _ = Handle("/Delete", CallerFunc(Delete))
+
+func TestNoContent(t *testing.T) {
+	h := GetHandlerForPattern("/Delete")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "http://host/Delete", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 204)
+	if recorder.Body.Len() != 0 {
+		t.Fatal("unexpected body")
+	}
+}
+
+func TestNilReader(t *testing.T) {
+	if Reader(nil) != nil {
+		t.Fatal("Reader(nil) not nil")
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	for _, c := range []struct {
+		s    Settings
+		want string
+	}{
+		{Settings{Attachment: true}, "attachment"},
+		{Settings{Filename: "a.txt"}, "inline; filename=\"a.txt\""},
+		{Settings{Attachment: true, Filename: "say \"hi\".csv"}, "attachment; filename=\"say \\\"hi\\\".csv\""},
+		{Settings{Attachment: true, Filename: "naïve résumé.pdf"},
+			"attachment; filename=\"na_ve r_sum_.pdf\"; filename*=UTF-8''na%C3%AFve%20r%C3%A9sum%C3%A9.pdf"},
+	} {
+		if v := contentDisposition(&c.s); v != c.want {
+			t.Fatalf("got %s want %s", v, c.want)
+		}
+	}
+}
+
+func Sized(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return bytes.NewBufferString(string(args["text"].(SafeString))), &Settings{StatusCode: 202, BufferSize: 8}, nil
+}
+
+var _ = Handle("/Sized?text SafeString", CallerFunc(Sized))
+
+func TestBufferSize(t *testing.T) {
+	h := GetHandlerForPattern("/Sized?text SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Sized?text=short", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 202)
+	if recorder.Header().Get("Content-Length") != "5" || recorder.Body.String() != "short" {
+		t.Fatal("Content-Length header missing or incorrect response")
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Sized?text=much_longer", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 202)
+	if recorder.Header().Get("Content-Length") != "" || recorder.Body.String() != "much_longer" {
+		t.Fatal("unexpected Content-Length header or incorrect response")
+	}
+}
+
+// A ResponseWriter that records use of ReadFrom
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func SendFile(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	f, err := os.Open("README")
+	if err != nil {
+		return nil, nil, err
+	}
+	return Reader(f), &Settings{Sendfile: true, Gzip: true}, nil
+}
+
+var _ = Handle("/SendFile", CallerFunc(SendFile))
+
+func TestSendfile(t *testing.T) {
+	h := GetHandlerForPattern("/SendFile")
+	readme, _ := os.ReadFile("README")
+
+	recorder := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	request, _ := http.NewRequest("GET", "http://host/SendFile", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder.ResponseRecorder, 200)
+	if !recorder.readFrom || recorder.Body.String() != string(readme) {
+		t.Fatal("incorrect response or ReadFrom not used")
+	}
+	if recorder.Header().Get("Content-Length") != strconv.Itoa(len(readme)) {
+		t.Fatal("Content-Length header missing or invalid")
+	}
+	if recorder.Header().Get("Content-Encoding") != "" {
+		t.Fatal("Unexpected Content-Encoding")
+	}
+}
+
+// An io.WriterTo that fails after writing some bytes
+type failWriterTo int
+
+func (f failWriterTo) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(bytes.Repeat([]byte("x"), int(f)))
+	if err != nil {
+		return int64(n), err
+	}
+	return int64(n), errors.New("failed")
+}
+
+func Fail(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	mode, size := StreamErrorDefault, 100
+	switch args["mode"].(SafeString) {
+	case "abort":
+		mode = StreamErrorAbort
+	case "trailer":
+		mode = StreamErrorTrailer
+	case "buffer":
+		size = 10
+	}
+	return failWriterTo(10000), &Settings{StreamError: mode, BufferSize: size, Gzip: true, Cache: 60}, nil
+}
+
+var _ = Handle("/Fail?mode SafeString", CallerFunc(Fail))
+
+var _ = HandleWithOptions("/Logged/SendFile", CallerFunc(SendFile), &Options{AccessLog: io.Discard, Metrics: MetricsFunc(func(RequestMetrics) {})})
+
+func TestSendfileWrapped(t *testing.T) {
+	h := GetHandlerForPattern("/Logged/SendFile")
+
+	recorder := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	request, _ := http.NewRequest("GET", "http://host/Logged/SendFile", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder.ResponseRecorder, 200)
+	if !recorder.readFrom {
+		t.Fatal("ReadFrom not used")
+	}
+}
+
+var _ = HandleWithOptions("/Limited/SendFile", CallerFunc(SendFile), &Options{MaxResponseSize: 10})
+
+func TestSendfileMaxResponseSize(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Limited/SendFile", nil)
+	GetHandlerForPattern("/Limited/SendFile").ServeHTTP(recorder, request)
+	checkCode(t, recorder, 500)
+	if recorder.Header().Get("Content-Length") != "" && recorder.Header().Get("Content-Length") != strconv.Itoa(recorder.Body.Len()) {
+		t.Fatal("file Content-Length sent")
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	h := GetHandlerForPattern("/Fail?mode SafeString")
+
+	request, _ := http.NewRequest("GET", "http://host/Fail?mode=buffer", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 500)
+	if recorder.Header().Get("Content-Encoding") != "" {
+		t.Fatal("Unexpected Content-Encoding")
+	}
+	for _, k := range []string{"Cache-Control", "Expires", "Vary"} {
+		if v := recorder.Header().Get(k); v != "" {
+			t.Fatalf("Unexpected %s: %s", k, v)
+		}
+	}
+
+	request, _ = http.NewRequest("GET", "http://host/Fail?mode=trailer", nil)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Result().Trailer.Get("Httpize-Error") != "failed" {
+		t.Fatal("Httpize-Error trailer missing")
+	}
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Fatal("handler not aborted")
+		}
+	}()
+	request, _ = http.NewRequest("GET", "http://host/Fail?mode=abort", nil)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, request)
+}
+
+var _ = HandleWithOptions("/PrettyEcho?name SafeString", ValueFunc(EchoValue), &Options{PrettyParam: "pretty", JSONPParam: "callback"})
+
+func TestPrettyJSONP(t *testing.T) {
+	h := GetHandlerForPattern("/PrettyEcho?name SafeString")
+
+	for query, body := range map[string]string{
+		"name=Gopher":                      "{\"name\":\"Gopher\"}\n",
+		"name=Gopher&pretty=1":             "{\n  \"name\": \"Gopher\"\n}\n",
+		"name=Gopher&callback=app.cb":      "/**/app.cb({\"name\":\"Gopher\"});\n",
+		"name=Gopher&callback=cb&pretty=0": "/**/cb({\"name\":\"Gopher\"});\n",
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/PrettyEcho?"+query, nil)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 200)
+		if recorder.Body.String() != body {
+			t.Fatalf("%s: incorrect response", query)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/PrettyEcho?name=Gopher&callback=alert(1)", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 400)
+}
+
+func TestCacheControl(t *testing.T) {
+	s := &Settings{Cache: 60, MustRevalidate: true}
+	if v := cacheControl(s, true, false); v != "max-age=60, must-revalidate" {
+		t.Fatalf("got %s", v)
+	}
+	if v := cacheControl(s, false, false); v != "must-revalidate" {
+		t.Fatalf("got %s", v)
+	}
+	s = &Settings{NoStore: true, NoCache: true}
+	if v := cacheControl(s, true, false); v != "no-store, no-cache" {
+		t.Fatalf("got %s", v)
+	}
+	s = &Settings{Cache: 60, CachePrivate: true}
+	if v := cacheControl(s, true, false); v != "private, max-age=60" {
+		t.Fatalf("got %s", v)
+	}
+	s = &Settings{Cache: 60, CachePublic: true}
+	if v := cacheControl(s, true, false); v != "public, max-age=60" {
+		t.Fatalf("got %s", v)
+	}
+	if v := cacheControl(s, true, true); v != "public, max-age=60" {
+		t.Fatalf("got %s", v)
+	}
+	s = &Settings{Cache: 60}
+	if v := cacheControl(s, true, true); v != "private, max-age=60" {
+		t.Fatalf("got %s", v)
+	}
+}
+
+func NilSettings(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return bytes.NewBufferString("{}"), nil, nil
+}
+
+var _ = HandleWithOptions("/NilSettings", CallerFunc(NilSettings), &Options{
+	Settings: NewSettings().WithContentType("application/json").WithCache(60),
+})
+
+func TestOptionsSettings(t *testing.T) {
+	h := GetHandlerForPattern("/NilSettings")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/NilSettings", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Header().Get("Content-Type") != "application/json; charset=utf-8" || recorder.Header().Get("Expires") == "" {
+		t.Fatal("Options.Settings not used")
+	}
+
+	recorder = httptest.NewRecorder()
+	request.Header.Set("Authorization", "Bearer x")
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Header().Get("Expires") != "" || recorder.Header().Get("Cache-Control") != "private, max-age=60" {
+		t.Fatal("Expires set for authenticated request")
+	}
+}
+
+// A Caller with a CallValidator that returns nil Settings
+type ValidatedFunc CallerFunc
+
+func (f ValidatedFunc) Call(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return f(args)
+}
+
+func (f ValidatedFunc) ValidateCall(methodName string, args map[string]Arg) error {
+	return RangeFunc(nil).ValidateCall(methodName, args)
+}
+
+var _ = HandleWithOptions("/StaticSettings?from SafeString&to SafeString",
+	WithSettings(ValidatedFunc(NilSettings), NewSettings().WithContentType("text/plain")),
+	&Options{Settings: NewSettings().WithContentType("application/json")})
+
+func TestWithSettings(t *testing.T) {
+	h := GetHandlerForPattern("/StaticSettings?from SafeString&to SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/StaticSettings?from=a&to=b", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatal("WithSettings Settings not used")
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/StaticSettings?from=b&to=a", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 400)
+}
+
+// A ContextCaller that waits for its context if asked to
+type SlowFunc struct{}
+
+func (f SlowFunc) Call(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, errors.New("Call used instead of CallContext")
+}
+
+func (f SlowFunc) CallContext(ctx context.Context, args map[string]Arg) (io.WriterTo, *Settings, error) {
+	if args["wait"].(SafeString) == "yes" {
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}
+	return bytes.NewBufferString("fast"), nil, nil
+}
+
+var _ = HandleWithOptions("/Slow?wait SafeString", SlowFunc{}, &Options{Timeout: 10 * time.Millisecond})
+
+func TestTimeout(t *testing.T) {
+	h := GetHandlerForPattern("/Slow?wait SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Slow?wait=no", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != "fast" {
+		t.Fatal("incorrect response")
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Slow?wait=yes", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 504)
+}
+
+var _ = HandleWithOptions("/Limited?mode SafeString", CallerFunc(Fail), &Options{MaxResponseSize: 50})
+
+func TestMaxResponseSize(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Limited?mode=trailer", nil)
+	GetHandlerForPattern("/Limited?mode SafeString").ServeHTTP(recorder, request)
+	checkCode(t, recorder, 500)
+	if recorder.Body.Len() > 50 {
+		t.Fatal("response larger than limit")
+	}
+
+	w := &limitWriter{new(bytes.Buffer), 5}
+	if n, err := w.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatal("limitWriter failed under limit")
+	}
+	if n, err := w.Write([]byte("abc")); n != 2 || err == nil {
+		t.Fatal("limitWriter did not fail over limit")
+	}
+}
+
+var _ = HandleWithOptions("/SettingsFunc", CallerFunc(NilSettings), &Options{
+	Settings: NewSettings().WithCache(60),
+	SettingsFunc: func(req *http.Request, s *Settings) *Settings {
+		if req.Header.Get("User-Agent") == "cdn" {
+			s.Cache = 3600
+			return s
+		}
+		return nil
+	},
+})
+
+func TestSettingsFunc(t *testing.T) {
+	h := GetHandlerForPattern("/SettingsFunc")
+
+	for ua, cc := range map[string]string{"cdn": "max-age=3600", "browser": "max-age=60"} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/SettingsFunc", nil)
+		request.Header.Set("User-Agent", ua)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 200)
+		if v := recorder.Header().Get("Cache-Control"); v != cc {
+			t.Fatalf("%s: Cache-Control %s", ua, v)
+		}
+	}
+}
+
+var _ = Handle("/Throttled", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, RetryableError{429, "too many requests", 1500 * time.Millisecond}
+}))
+
+func TestRetryAfter(t *testing.T) {
+	h := GetHandlerForPattern("/Throttled")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Throttled", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 429)
+	if v := recorder.Header().Get("Retry-After"); v != "2" {
+		t.Fatalf("Retry-After %s", v)
+	}
+}
+
+type testLogger struct {
+	errors []string
+}
+
+func (l *testLogger) Errorf(format string, v ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Infof(format string, v ...interface{}) {}
+
+var logged = new(testLogger)
+
+var _ = HandleWithOptions("/Logged", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, errors.New("failed")
+}), &Options{Logger: logged})
+
+func TestLogger(t *testing.T) {
+	h := GetHandlerForPattern("/Logged")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Logged", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 500)
+	if len(logged.errors) != 1 || logged.errors[0] != "failed" {
+		t.Fatalf("logged %v", logged.errors)
+	}
+}
+
+func TestDefaultOptionsLogger(t *testing.T) {
+	l := new(testLogger)
+	DefaultOptions.Logger = l
+	defer func() { DefaultOptions.Logger = nil }()
+
+	if Handle("/Unregistered?a NoSuchType", CallerFunc(NilSettings)) {
+		t.Fatal("added handler with unknown type")
+	}
+	if Handle("/Unregistered?a", CallerFunc(NilSettings)) {
+		t.Fatal("added handler with bad pattern")
+	}
+	if len(l.errors) != 2 {
+		t.Fatalf("logged %v", l.errors)
+	}
+}
+
+var slogged = new(bytes.Buffer)
+
+var _ = HandleWithOptions("/Slogged", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, errors.New("failed")
+}), &Options{Logger: SlogLogger(slog.New(slog.NewTextHandler(slogged, nil)))})
+
+func TestStructuredLogger(t *testing.T) {
+	h := GetHandlerForPattern("/Slogged")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Slogged", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 500)
+	for _, f := range []string{"msg=failed", "method=Slogged", "remote_addr=10.0.0.1:1234", "url=", "duration=", "status=500"} {
+		if !strings.Contains(slogged.String(), f) {
+			t.Fatalf("%s not in %s", f, slogged.String())
+		}
+	}
+}
+
+var panicked interface{}
+
+func Panic(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	panic("oops")
+}
+
+var _ = HandleWithOptions("/Panic", CallerFunc(Panic), &Options{
+	Logger:    new(testLogger),
+	PanicHook: func(req *http.Request, v interface{}, stack []byte) { panicked = v },
+})
+
+var _ = HandleWithOptions("/PanicTimeout", CallerFunc(Panic), &Options{
+	Logger:  new(testLogger),
+	Timeout: time.Second,
+})
+
+func TestPanicRecovery(t *testing.T) {
+	for _, p := range []string{"/Panic", "/PanicTimeout"} {
+		h := GetHandlerForPattern(p)
+
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host"+p, nil)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 500)
+	}
+	if panicked != "oops" {
+		t.Fatalf("PanicHook not called: %v", panicked)
+	}
+	l := GetHandlerForPattern("/PanicTimeout").(*handler).options.Logger.(*testLogger)
+	if len(l.errors) != 1 || !strings.Contains(l.errors[0], "panic: oops") {
+		t.Fatalf("logged %v", l.errors)
+	}
+}
+
+var _ = HandleWithOptions("/JSONError?id SafeString", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, Non500Error{404, "not found", ""}
+}), &Options{ErrorRenderer: JSONErrorRenderer})
+
+func TestErrorRenderer(t *testing.T) {
+	h := GetHandlerForPattern("/JSONError?id SafeString")
+
+	for url, body := range map[string]string{
+		"http://host/JSONError?id=1": `{"code":404,"message":"not found"}`,
+		"http://host/JSONError":      `{"code":400,"message":"missing parameter id"}`,
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", url, nil)
+		h.ServeHTTP(recorder, request)
+		if recorder.Header().Get("Content-Type") != "application/json; charset=utf-8" || strings.TrimSpace(recorder.Body.String()) != body {
+			t.Fatalf("%s: %s", url, recorder.Body.String())
+		}
+	}
+}
+
+var _ = Handle("/Conflict", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, ResponseError{
+		Code:    409,
+		Message: "conflict",
+		Header:  http.Header{"Content-Type": {"application/json"}, "X-Version": {"3"}},
+		Body:    strings.NewReader(`{"version":3}`),
+	}
+}))
+
+func TestResponseError(t *testing.T) {
+	h := GetHandlerForPattern("/Conflict")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Conflict", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 409)
+	if recorder.Header().Get("X-Version") != "3" || recorder.Header().Get("Content-Type") != "application/json" || recorder.Body.String() != `{"version":3}` {
+		t.Fatalf("%v %s", recorder.Header(), recorder.Body.String())
+	}
+}
+
+var errNoRows = errors.New("no rows")
+
+func Mapped(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	switch args["err"].(SafeString) {
+	case "norows":
+		return nil, nil, fmt.Errorf("lookup: %w", errNoRows)
+	case "path":
+		_, err := os.Open("/does/not/exist")
+		return nil, nil, err
+	}
+	return nil, nil, errors.New("other")
+}
+
+var _ = HandleWithOptions("/Mapped?err SafeString", CallerFunc(Mapped), (&Options{Logger: new(testLogger)}).
+	MapError(nil, 400).
+	MapError(errNoRows, 404).
+	MapError(&os.PathError{}, 503))
+
+func TestMapError(t *testing.T) {
+	h := GetHandlerForPattern("/Mapped?err SafeString")
+
+	for e, code := range map[string]int{"norows": 404, "path": 503, "other": 500} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/Mapped?err="+e, nil)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, code)
+	}
+}
+
+var _ = HandleWithOptions("/AllArgErrors?a SafeString&b SafeString&c SafeString", CallerFunc(NilSettings), &Options{
+	AllArgErrors:  true,
+	ErrorRenderer: JSONErrorRenderer,
+})
+
+func TestAllArgErrors(t *testing.T) {
+	h := GetHandlerForPattern("/AllArgErrors?a SafeString&b SafeString&c SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/AllArgErrors?a=%27&c=%27", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 400)
+	want := `{"code":400,"message":"invalid parameters: a: SafeString in wrong format; b: missing; c: SafeString in wrong format","errors":[{"parameter":"a","message":"SafeString in wrong format"},{"parameter":"b","message":"missing"},{"parameter":"c","message":"SafeString in wrong format"}]}`
+	if strings.TrimSpace(recorder.Body.String()) != want {
+		t.Fatalf("got %s", recorder.Body.String())
+	}
+}
+
+var _ = HandleWithOptions("/Debug", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, errors.New("database down")
+}), &Options{Debug: true, Logger: new(testLogger)})
+
+var _ = HandleWithOptions("/DebugPanic", CallerFunc(Panic), &Options{Debug: true, Logger: new(testLogger)})
+
+func TestDebug(t *testing.T) {
+	for p, body := range map[string]string{"/Logged": "error", "/Debug": "database down", "/DebugPanic": "panic: oops"} {
+		h := GetHandlerForPattern(p)
+
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host"+p, nil)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 500)
+		if !strings.HasPrefix(recorder.Body.String(), body) {
+			t.Fatalf("%s: %s", p, recorder.Body.String())
+		}
+	}
+}
+
+var _ = Handle("/Wrapped", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, fmt.Errorf("loading item: %w", Non500Error{404, "no such item", ""})
+}))
+
+func TestWrappedHTTPError(t *testing.T) {
+	h := GetHandlerForPattern("/Wrapped")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Wrapped", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 404)
+	if strings.TrimSpace(recorder.Body.String()) != "no such item" {
+		t.Fatalf("got %s", recorder.Body.String())
+	}
+}
+
+func tagMiddleware(tag string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.Header().Add("X-Middleware", tag+":"+MethodName(req))
+			if req.Header.Get("X-Deny") != "" {
+				http.Error(resp, "denied", 403)
+				return
+			}
+			next.ServeHTTP(resp, req)
+		})
+	}
+}
+
+var _ = HandleWithOptions("/Wrapped/Middleware", CallerFunc(NilSettings), new(Options).
+	Use(tagMiddleware("outer")).
+	Use(tagMiddleware("inner")))
+
+func TestMiddleware(t *testing.T) {
+	h := GetHandlerForPattern("/Wrapped/Middleware")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Wrapped/Middleware", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if v := strings.Join(recorder.Header()["X-Middleware"], ","); v != "outer:Middleware,inner:Middleware" {
+		t.Fatalf("X-Middleware %s", v)
+	}
+
+	recorder = httptest.NewRecorder()
+	request.Header.Set("X-Deny", "1")
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 403)
+}
+
+// A Caller with BeforeCall and AfterCall hooks
+type hookedCaller struct {
+	calls []string
+}
+
+func (c *hookedCaller) Call(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	c.calls = append(c.calls, "call")
+	return bytes.NewBufferString("hooked"), nil, nil
+}
+
+func (c *hookedCaller) BeforeCall(methodName string, args map[string]Arg, req *http.Request) error {
+	c.calls = append(c.calls, "before "+methodName+" "+string(args["user"].(SafeString)))
+	if args["user"].(SafeString) != "admin" {
+		return Non500Error{403, "forbidden", ""}
+	}
+	return nil
+}
+
+func (c *hookedCaller) AfterCall(methodName string, d time.Duration, err error) {
+	c.calls = append(c.calls, fmt.Sprintf("after %s %v", methodName, err))
+}
+
+var hooked = new(hookedCaller)
+
+var _ = Handle("/Hooked?user SafeString", hooked)
+
+func TestCallHooks(t *testing.T) {
+	h := GetHandlerForPattern("/Hooked?user SafeString")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Hooked?user=admin", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Hooked?user=guest", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 403)
+
+	if v := strings.Join(hooked.calls, ","); v != "before Hooked admin,call,after Hooked <nil>,before Hooked guest" {
+		t.Fatalf("calls %s", v)
+	}
+}
+
+var _ = HandleWithOptions("/Guarded", CallerFunc(NilSettings), &Options{MaxPathLength: 10, MaxParams: 2, IgnoreUnknown: true})
+
+func TestURLGuards(t *testing.T) {
+	h := GetHandlerForPattern("/Guarded")
+
+	for url, code := range map[string]int{
+		"http://host/Guarded?a=1&b=2":     200,
+		"http://host/Guarded?a=1&b=2&":    200,
+		"http://host/Guarded?a=1&&b=2":    200,
+		"http://host/Guarded?&a=1&b=2&&":  200,
+		"http://host/Guarded?a=1&b=2&c=3": 400,
+		"http://host/Guarded?a=1;b=2;c=3": 400,
+		"http://host/x/y/z/Guarded":       414,
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", url, nil)
+		h.ServeHTTP(recorder, request)
+		if recorder.Code != code {
+			t.Fatalf("%s: got %d", url, recorder.Code)
+		}
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	path, a, err := ParsePattern("/a/Page?q SafeString&page SafeString=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ArgDef{
+		{Key: "q", Type: "SafeString", Required: true},
+		{Key: "page", Type: "SafeString", Default: "1"},
+	}
+	if path != "/a/Page" || !reflect.DeepEqual(a, want) {
+		t.Fatalf("got %s %+v", path, a)
+	}
+	if _, _, err := ParsePattern("/a/Page?q"); err == nil {
+		t.Fatal("no error for invalid pattern")
+	}
+}
@@ -0,0 +1,80 @@
+package httpize
+
+import (
+	"strconv"
+	"strings"
+)
+
+type quality struct {
+	value string
+	q     float64
+}
+
+// parseQualities parses a header like Accept or Accept-Encoding into its
+// values and q-values. Values without a q parameter have q 1.
+func parseQualities(header string) []quality {
+	var qs []quality
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		v := strings.ToLower(strings.TrimSpace(params[0]))
+		if v == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				f, err := strconv.ParseFloat(p[2:], 64)
+				if err == nil {
+					q = f
+				}
+			}
+		}
+		qs = append(qs, quality{v, q})
+	}
+	return qs
+}
+
+// mediaQuality returns the q-value of the most specific media range in accept
+// matching contentType, or 0 if none match.
+func mediaQuality(accept []quality, contentType string) float64 {
+	contentType = strings.ToLower(contentType)
+	major := strings.SplitN(contentType, "/", 2)[0]
+	q, specificity := 0.0, -1
+	for _, a := range accept {
+		s := -1
+		switch {
+		case a.value == contentType:
+			s = 2
+		case a.value == major+"/*":
+			s = 1
+		case a.value == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = a.q, s
+		}
+	}
+	return q
+}
+
+// negotiateEncoder chooses one of the encoder names in offers using the
+// request Accept header. An empty header accepts the first offer. Returns ""
+// if no offer is acceptable.
+func negotiateEncoder(header string, offers []string) string {
+	if strings.TrimSpace(header) == "" {
+		return offers[0]
+	}
+	accept := parseQualities(header)
+	best, bestQ := "", 0.0
+	for _, name := range offers {
+		e, ok := lookupEncoder(name)
+		if !ok {
+			continue
+		}
+		if q := mediaQuality(accept, e.contentType); q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
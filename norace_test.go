@@ -0,0 +1,5 @@
+//go:build !race
+
+package httpize
+
+const raceEnabled = false
@@ -0,0 +1,102 @@
+package httpize
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAPIInfo is the info of an OpenAPI document.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPI returns an OpenAPI 3 document describing the methods added, as
+// returned by Methods. Query parameter arguments have the schema given with
+// AddTypeSchema, or are strings, with the type in the pattern given as
+// x-httpize-type. Methods with a Body argument can only be POSTed.
+func OpenAPI(info OpenAPIInfo) map[string]interface{} {
+	paths := make(map[string]interface{})
+	for _, m := range Methods() {
+		var params []interface{}
+		var body bool
+		for _, a := range m.Args {
+			switch a.Type {
+			case "Body":
+				body = true
+				continue
+			case "Principal", "Session":
+				continue
+			}
+			schema := map[string]interface{}{"type": "string"}
+			for k, v := range a.Schema {
+				schema[k] = v
+			}
+			schema["x-httpize-type"] = a.Type
+			if a.Default != "" {
+				schema["default"] = a.Default
+			}
+			params = append(params, map[string]interface{}{
+				"name":     a.Name,
+				"in":       "query",
+				"required": a.Required,
+				"schema":   schema,
+			})
+		}
+
+		item := make(map[string]interface{})
+		for _, verb := range m.Verbs {
+			if body && verb == "GET" {
+				continue
+			}
+			op := map[string]interface{}{
+				"operationId": m.Name,
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "OK",
+						"content":     map[string]interface{}{m.ContentType: map[string]interface{}{}},
+					},
+					"400": map[string]interface{}{"description": "Missing or invalid argument"},
+				},
+			}
+			if len(item) > 0 {
+				op["operationId"] = m.Name + verb[:1] + strings.ToLower(verb[1:])
+			}
+			if params != nil {
+				op["parameters"] = params
+			}
+			if body && verb == "POST" {
+				op["requestBody"] = map[string]interface{}{
+					"content": map[string]interface{}{"application/octet-stream": map[string]interface{}{}},
+				}
+			}
+			item[strings.ToLower(verb)] = op
+		}
+		paths[m.Path] = item
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    info,
+		"paths":   paths,
+	}
+}
+
+// WriteOpenAPI writes the document returned by OpenAPI to w as JSON.
+func WriteOpenAPI(w io.Writer, info OpenAPIInfo) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(OpenAPI(info))
+}
+
+// HandleOpenAPI adds a handler at path responding with the document returned
+// by OpenAPI, like "/openapi.json". Always returns true.
+func HandleOpenAPI(path string, info OpenAPIInfo) bool {
+	http.HandleFunc(path, func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "application/json")
+		WriteOpenAPI(resp, info)
+	})
+	return true
+}
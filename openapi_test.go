@@ -0,0 +1,58 @@
+package httpize
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var _ = HandleOpenAPI("/openapi.json", OpenAPIInfo{Title: "Test", Version: "1"})
+
+func TestOpenAPI(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteOpenAPI(&buf, OpenAPIInfo{Title: "Test", Version: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		OpenAPI string
+		Info    OpenAPIInfo
+		Paths   map[string]map[string]struct {
+			OperationID string
+			Parameters  []struct {
+				Name     string
+				In       string
+				Required bool
+			}
+			RequestBody map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" || doc.Info.Title != "Test" {
+		t.Fatalf("got %+v", doc)
+	}
+
+	upload := doc.Paths["/Upload"]
+	if _, ok := upload["get"]; ok {
+		t.Error("GET of method with Body argument")
+	}
+	post := upload["post"]
+	if post.OperationID != "Upload" || post.RequestBody == nil || len(post.Parameters) != 1 ||
+		post.Parameters[0].Name != "name" || post.Parameters[0].In != "query" || !post.Parameters[0].Required {
+		t.Errorf("got %+v", post)
+	}
+	if get := doc.Paths["/Greet"]["get"]; get.OperationID != "Greet" || len(get.Parameters) != 1 {
+		t.Errorf("got %+v", get)
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/openapi.json", nil)
+	http.DefaultServeMux.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if !bytes.Equal(recorder.Body.Bytes(), buf.Bytes()) {
+		t.Error("served document differs")
+	}
+}
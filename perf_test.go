@@ -0,0 +1,115 @@
+package httpize
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var _ = Handle("/PerfNone", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return strings.NewReader("ok"), nil, nil
+}))
+
+var _ = Handle("/PerfArgs?a SafeString&b SafeString&c SafeString", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return strings.NewReader(string(args["a"].(SafeString))), nil, nil
+}))
+
+var perfText = strings.Repeat("some text that compresses well ", 64)
+
+var _ = Handle("/PerfGzip", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return strings.NewReader(perfText), &Settings{Gzip: true}, nil
+}))
+
+var _ = Handle("/PerfError", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, Non500Error{404, "not found", ""}
+}))
+
+// discardWriter is a http.ResponseWriter that allocates as little as possible,
+// so the handlers allocations can be measured.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *discardWriter) WriteHeader(int) {}
+
+func (w *discardWriter) reset() {
+	for k := range w.header {
+		delete(w.header, k)
+	}
+}
+
+var perfCases = []struct {
+	name, pattern, url string
+	gzip               bool
+	// most allocations a request should take
+	allocs float64
+}{
+	{"None", "/PerfNone", "http://host/PerfNone", false, 8},
+	{"Args", "/PerfArgs?a SafeString&b SafeString&c SafeString", "http://host/PerfArgs?a=1&b=2&c=3", false, 16},
+	{"Gzip", "/PerfGzip", "http://host/PerfGzip", true, 14},
+	{"Error", "/PerfError", "http://host/PerfError", false, 8},
+}
+
+// perfRequest returns a func making a request to pattern.
+func perfRequest(pattern, url string, gzip bool) func() {
+	h := GetHandlerForPattern(pattern)
+	request, _ := http.NewRequest("GET", url, nil)
+	if gzip {
+		request.Header.Set("Accept-Encoding", "gzip")
+	}
+	w := &discardWriter{make(http.Header)}
+	return func() {
+		w.reset()
+		h.ServeHTTP(w, request)
+	}
+}
+
+func TestNoArgs(t *testing.T) {
+	h := GetHandlerForPattern("/PerfNone")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/PerfNone", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/PerfNone?x=1", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 400)
+}
+
+// TestAllocs checks requests stay within their allocation budgets. If a change
+// makes requests take fewer allocations lower the budget.
+func TestAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("race detector enabled")
+	}
+	for _, c := range perfCases {
+		n := testing.AllocsPerRun(100, perfRequest(c.pattern, c.url, c.gzip))
+		if n > c.allocs {
+			t.Errorf("%s: %v allocations, budget is %v", c.name, n, c.allocs)
+		}
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	for _, c := range perfCases {
+		b.Run(c.name, func(b *testing.B) {
+			f := perfRequest(c.pattern, c.url, c.gzip)
+			b.ReportAllocs()
+			for b.Loop() {
+				f()
+			}
+		})
+	}
+}
@@ -1,72 +1,466 @@
 package httpize
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"reflect"
 	"regexp"
 	"strings"
+	"time"
 )
 
-// for testing
+// handlers added, by pattern
 var handlers = make(map[string]http.Handler)
 
 // Add pattern to be handled. p: is a pattern to be handled. Patterns are like
 // [path/]name[?arguments]. If path/ is ommitted "/" is used. Arguments are
 // a ampersand seprated list of two words. Where words are seperated by whitespace.
 // First word is the key used to get a value from query part of the URL.
-// The second word is a type registered with AddType. The patttern will match urls
+// The second word is a type registered with AddType, or Body to be passed the
+// request body as a Body value, or Principal to be passed the authenticated
+// Principal as a PrincipalArg value, or Session to be passed the *Session of
+// the request. The type can be followed by =default to make
+// the argument optional, if default is empty the argument is left out of the
+// map passed to Call when not in the URL. The patttern will match urls
 // [path/]name?arg1_key=...&arg2_key=... etc. c is a Caller interface that
 // will be called when pattern matches a given HTTP request. It will be
-// passed arguments as specified by the pattern. Always returns true.
+// passed arguments as specified by the pattern. Returns false if the handler
+// could not be added, the error is logged.
 func Handle(p string, c Caller) bool {
-	re, _ := regexp.Compile("^([^\\?]+)\\??([&,*,0-9,a-z,A-Z,_, ,\t]*)$")
-	parts := re.FindStringSubmatch(p)
+	return HandleWithOptions(p, c, nil)
+}
+
+// Options are per handler options that can be given to HandleWithOptions. A
+// handler keeps a pointer to its Options, so they can be changed after the
+// handler is added but before it handles requests. Collapse, Expvar,
+// Metrics, Tracer, AccessLog and middleware added with Use are read when a
+// handler is added, so must be set before handlers using the Options are
+// added.
+type Options struct {
+	// Ignore query parameters that are not arguments in the pattern,
+	// otherwise they cause an error response
+	IgnoreUnknown bool
+	// What to do when an argument is given more than once in the query
+	Duplicates DuplicatePolicy
+	// Check all arguments and respond with one 400 error listing all missing
+	// and invalid arguments as ArgErrors, rather than just the first
+	AllArgErrors bool
+	// Maximum number of bytes of the request body, 0 for no limit. Requests
+	// with a larger Content-Length get a 413 error, and reading more from a
+	// Body argument fails with an error that when returned by a Caller gives
+	// a 413 error.
+	MaxBodySize int64
+	// Maximum length of the query part of the URL, 0 for no limit. Requests
+	// with longer queries get a 414 error.
+	MaxQueryLength int
+	// Maximum length of the escaped path of the URL, 0 for no limit.
+	// Requests with longer paths get a 414 error.
+	MaxPathLength int
+	// Maximum number of query parameters, 0 for no limit. Requests with more
+	// get a 400 error. They are checked before the query is parsed.
+	MaxParams int
+	// If > 0 the time a call can take before the handler responds with a
+	// 504 error. A ContextCaller is given a context that is done then. The
+	// time also limits writing the response body.
+	Timeout time.Duration
+	// Maximum number of bytes, before compression, of response bodies, 0 for
+	// no limit. If exceeded writing the response stops with an error.
+	MaxResponseSize int64
+	// Settings used when a Caller returns nil Settings, if nil defaults as
+	// per Settings.SetToDefault() are used
+	Settings *Settings
+	// Called with the request and a copy of the Settings to be used after the
+	// Caller returns. The Settings it returns are used instead, unless nil.
+	SettingsFunc func(*http.Request, *Settings) *Settings
+	// Show the error message in the body of 500 responses, and the stack for
+	// panics. For development only, as it can show internal details.
+	Debug bool
+	// Logger for messages from the handler, if nil the log package is used
+	Logger Logger
+	// Writes error responses instead of the default plain text ones. err is
+	// the error causing the response, for 500 errors it may have details
+	// that should not be shown to clients.
+	ErrorRenderer func(resp http.ResponseWriter, req *http.Request, status int, err error)
+	// Errors added with MapError
+	errorMap []errorMapping
+	// Middleware added with Use
+	middleware []func(http.Handler) http.Handler
+	// Called after a panic in a Caller or while writing the response is
+	// recovered, logged and responded to with a 500 error. v is the value
+	// passed to panic, stack is where it happened.
+	PanicHook func(req *http.Request, v interface{}, stack []byte)
+	// Authenticates requests before arguments are parsed. The Principal is
+	// available from the request context, see PrincipalOf
+	Authenticator Authenticator
+	// Requests must be authenticated by Authenticator, otherwise they get a
+	// 401 error
+	RequireAuth bool
+	// Given an AuditEntry for each call
+	Audit AuditSink
+	// If set responses to POST requests with an Idempotency-Key header are
+	// stored in it and replayed for requests with the same key
+	Idempotency IdempotencyStore
+	// If set responses to GET requests with Settings.Cache set are stored in
+	// it and served for requests with the same arguments
+	ResponseCache ResponseCache
+	// Identical GET requests handled at the same time share the response of
+	// one call, rather than each calling the method. The response is buffered
+	// to be shared. Must be set before handlers are added.
+	Collapse bool
+	// If not "" the request, error and in flight counts of each method are
+	// published with expvar, in a map of this name keyed by method name. Must
+	// be set before handlers are added.
+	Expvar string
+	// Given the RequestMetrics of each request. Must be set before handlers
+	// are added.
+	Metrics MetricsSink
+	// Starts a Span for each request. Must be set before handlers are added.
+	Tracer Tracer
+	// Spans are given the values of arguments, except ones with
+	// ArgDef.Redact set
+	TraceArgValues bool
+	// A line is written to it for each request in AccessLogFormat. Must be
+	// set before handlers are added.
+	AccessLog       io.Writer
+	AccessLogFormat AccessLogFormat
+	// If set only URLs signed by it are accepted
+	SignedURLs *URLSigner
+	// Allows or denies requests by client IP address
+	IPFilter *IPFilter
+	// Limits the rate of requests to all handlers using the Options
+	RateLimit *RateLimit
+	// Loads and saves the values of Session arguments
+	Sessions SessionStore
+	// If set POST requests must have a CSRF token
+	CSRF *CSRF
+	// Security headers for all responses, unless Settings.Security is set
+	Security *SecurityHeaders
+	// Templates used to execute a TemplateResult
+	Templates *template.Template
+	// If not empty only responses with these content types are compressed.
+	// A type can be like "text/*" to match all subtypes.
+	CompressTypes []string
+	// Responses with these content types are not compressed, if nil
+	// images, audio, video and compressed archives are not compressed
+	NoCompressTypes []string
+	// Name of a query parameter that when set to 1 or true makes JSON
+	// encoded values indented, eg "pretty". Not used if ""
+	PrettyParam string
+	// Name of a query parameter giving a JSONP callback to wrap JSON encoded
+	// values in, eg "callback". Not used if ""
+	JSONPParam string
+}
+
+type errorMapping struct {
+	target error
+	status int
+}
+
+// MapError makes errors returned by Callers that match target respond with
+// status and its http.StatusText, rather than 500. An error matches if
+// errors.Is(err, target) is true. If target is the zero value of its type, or
+// a pointer to one, eg &os.PathError{}, an error also matches if errors.As
+// would find an error in it of the same type. Errors are matched in the order
+// added. A nil target is ignored. Returns o, so calls can be chained.
+func (o *Options) MapError(target error, status int) *Options {
+	if target == nil {
+		return o
+	}
+	o.errorMap = append(o.errorMap, errorMapping{target, status})
+	return o
+}
+
+// Use adds middleware that handlers using o are wrapped in. The first added is
+// the outermost. MethodName can be used by middleware to get the name of the
+// method of the request. Middleware must be added before handlers using o are
+// made. Returns o, so calls can be chained.
+func (o *Options) Use(m ...func(http.Handler) http.Handler) *Options {
+	o.middleware = append(o.middleware, m...)
+	return o
+}
+
+// mappedStatus returns the status code err is mapped to by MapError.
+func (o *Options) mappedStatus(err error) (int, bool) {
+	for _, m := range o.errorMap {
+		if errors.Is(err, m.target) {
+			return m.status, true
+		}
+		if !zero(m.target) {
+			continue
+		}
+		if errors.As(err, reflect.New(reflect.TypeOf(m.target)).Interface()) {
+			return m.status, true
+		}
+	}
+	return 0, false
+}
+
+// zero reports whether v is the zero value of its type or a pointer to one.
+func zero(v interface{}) bool {
+	r := reflect.ValueOf(v)
+	if r.Kind() == reflect.Ptr && !r.IsNil() {
+		r = r.Elem()
+	}
+	return r.IsZero()
+}
+
+// DefaultOptions are the Options used by Handle, and by HandleWithOptions and
+// HandleArgs when they are given nil Options.
+var DefaultOptions = new(Options)
+
+// Logger is used by handlers to log errors and other messages.
+type Logger interface {
+	Errorf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+}
+
+// StructuredLogger is a Logger that can log messages with fields. Errors from
+// handling a request are logged with ErrorAttrs when the Logger implements
+// it, with the fields method, remote_addr, url, duration and status.
+type StructuredLogger interface {
+	Logger
+	ErrorAttrs(msg string, attrs ...slog.Attr)
+}
+
+// SlogLogger returns a StructuredLogger that logs to l.
+func SlogLogger(l *slog.Logger) StructuredLogger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l slogLogger) Errorf(format string, v ...interface{}) {
+	l.l.Error(fmt.Sprintf(format, v...))
+}
+
+func (l slogLogger) Infof(format string, v ...interface{}) {
+	l.l.Info(fmt.Sprintf(format, v...))
+}
+
+func (l slogLogger) ErrorAttrs(msg string, attrs ...slog.Attr) {
+	l.l.LogAttrs(context.Background(), slog.LevelError, msg, attrs...)
+}
+
+// stdLogger logs using the log package
+type stdLogger struct{}
+
+func (stdLogger) Errorf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+func (stdLogger) Infof(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// logger returns o.Logger, or a Logger using the log package if o or
+// o.Logger is nil.
+func (o *Options) logger() Logger {
+	if o == nil || o.Logger == nil {
+		return stdLogger{}
+	}
+	return o.Logger
+}
+
+// DuplicatePolicy says how a handler deals with an argument given more than
+// once in the query part of the URL.
+type DuplicatePolicy int
+
+const (
+	// Respond with a 400 error naming the argument. This is the default.
+	DuplicateReject DuplicatePolicy = iota
+	// Use the first value given
+	DuplicateFirst
+	// Use the last value given
+	DuplicateLast
+)
+
+// ArgDef defines an argument of a handler. It is what an argument in a Handle
+// pattern is parsed to.
+type ArgDef struct {
+	// Key used to get the value from the query part of the URL
+	Key string
+	// Type registered with AddType, Body, Principal or Session
+	Type string
+	// Value used when the argument is not in the URL and Required is false,
+	// if "" the argument is left out of the map passed to Call
+	Default string
+	// Argument must be in the URL
+	Required bool
+	// Value is not shown in AuditEntry Args
+	Redact bool
+}
 
+// HandleWithOptions is like Handle but also takes Options to be used by the
+// handler. If o is nil DefaultOptions is used. Returns false if the handler
+// could not be added, the error is logged with o's Logger.
+func HandleWithOptions(p string, c Caller, o *Options) bool {
+	if o == nil {
+		o = DefaultOptions
+	}
+	path, a, err := ParsePattern(p)
+	if err != nil {
+		o.logger().Errorf("httpize.Export %s", err)
+		return false
+	}
+
+	handler := handle(path, c, a, o)
+	if handler == nil {
+		return false
+	}
+	// for tests to access handler
+	handlers[p] = handler
+	return true
+}
+
+var (
+	patternRegexp = regexp.MustCompile("^([^\\?]+)\\??([&,*,0-9,a-z,A-Z,_, ,\t,=,.,\\-]*)$")
+	emptyRegexp   = regexp.MustCompile("^\\s*$")
+	paramRegexp   = regexp.MustCompile("^\\s*([0-9a-zA-Z_]+)\\s+([*0-9a-zA-Z_]+)\\s*(=\\s*([^\\s]*))?\\s*$")
+)
+
+// ParsePattern parses a Handle pattern into the [path/]name and ArgDefs that
+// HandleArgs takes, so a handler added with a pattern is the same as one
+// added with HandleArgs and the ArgDefs.
+func ParsePattern(p string) (string, []ArgDef, error) {
+	parts := patternRegexp.FindStringSubmatch(p)
 	if parts == nil || parts[0] != p {
-		log.Printf("httpize.Export handler pattern wrong. %s", p)
-		return true
+		return "", nil, errors.New("handler pattern wrong. " + p)
 	}
-	pathParts := strings.Split(parts[1], "/")
-	l := len(pathParts)
-	path := strings.Join(pathParts[0:l-1], "/")
-	name := pathParts[l-1]
 
 	params := strings.Split(parts[2], "&")
-	re, _ = regexp.Compile("^\\s*$")
-	if re.MatchString(parts[2]) {
+	if emptyRegexp.MatchString(parts[2]) {
 		params = []string{}
 	}
 
-	a := make([]argBuilder, len(params))
+	a := make([]ArgDef, len(params))
 	for i, s := range params {
-		re, _ = regexp.Compile("([0-9,a-z,A-Z,_]+)\\s+([*,0-9,a-z,A-Z,_]+)")
-		paramParts := re.FindStringSubmatch(s)
-		if parts[1] == "" || parts[2] == "" {
-			log.Printf("httpize.Export handler pattern wrong. %s", p)
-			return true
+		paramParts := paramRegexp.FindStringSubmatch(s)
+		if paramParts == nil {
+			return "", nil, errors.New("handler pattern wrong. " + p)
+		}
+		a[i] = ArgDef{
+			Key:      paramParts[1],
+			Type:     paramParts[2],
+			Default:  paramParts[4],
+			Required: paramParts[3] == "",
+		}
+	}
+	return parts[1], a, nil
+}
+
+// HandleArgs is like HandleWithOptions but the arguments are given as a slice
+// of ArgDef rather than in a pattern. p is [path/]name. Returns false if the
+// handler could not be added.
+func HandleArgs(p string, c Caller, a []ArgDef, o *Options) bool {
+	handler := handle(p, c, a, o)
+	if handler == nil {
+		return false
+	}
+	handlers[p] = handler
+	return true
+}
+
+func handle(p string, c Caller, a []ArgDef, o *Options) *handler {
+	if o == nil {
+		o = DefaultOptions
+	}
+	pathParts := strings.Split(p, "/")
+	l := len(pathParts)
+	path := strings.Join(pathParts[0:l-1], "/")
+	name := pathParts[l-1]
+
+	b := make([]argBuilder, len(a))
+	for i, def := range a {
+		b[i].key = def.Key
+		b[i].typ = def.Type
+		b[i].def = def.Default
+		b[i].required = def.Required
+		b[i].redact = def.Redact
+		if def.Type == "Body" {
+			b[i].body = true
+			continue
 		}
-		createFunc, ok := types[paramParts[2]]
+		if def.Type == "Principal" {
+			b[i].principal = true
+			continue
+		}
+		if def.Type == "Session" {
+			b[i].session = true
+			continue
+		}
+
+		createFunc, ok := types[def.Type]
 		if !ok {
-			log.Println(
+			o.logger().Errorf(
 				"httpize.Export: %s not a Httpize registered type",
-				paramParts[2],
+				def.Type,
 			)
+			return nil
 		}
 
-		a[i].key = paramParts[1]
-		a[i].createFunc = createFunc
+		b[i].createFunc = createFunc
+		b[i].schema = typeSchemas[def.Type]
 	}
 
 	ds := new(Settings)
 	ds.SetToDefault()
 
-	handler := &handler{c, a, ds}
-	http.Handle(path+"/"+name, handler)
+	var settings *Settings
+	var roles []string
+	var rateLimit *RateLimit
+	var concurrency *ConcurrencyLimit
+	var ipFilter *IPFilter
+	for unwrapped := false; !unwrapped; {
+		switch wc := c.(type) {
+		case settingsCaller:
+			c, settings = wc.Caller, wc.settings
+		case rolesCaller:
+			c, roles = wc.Caller, wc.roles
+		case rateLimitCaller:
+			c, rateLimit = wc.Caller, wc.limit
+		case concurrencyCaller:
+			c, concurrency = wc.Caller, wc.limit
+		case ipFilterCaller:
+			c, ipFilter = wc.Caller, wc.filter
+		default:
+			unwrapped = true
+		}
+	}
+	for _, s := range []*Settings{settings, o.Settings} {
+		if s == nil {
+			continue
+		}
+		if err := s.Validate(); err != nil {
+			o.logger().Errorf("httpize.Export: %s settings invalid: %s", p, err)
+			return nil
+		}
+	}
 
-	// for tests to access handler
-	handlers[p] = handler
+	handler := &handler{
+		name:            name,
+		path:            path + "/" + name,
+		caller:          c,
+		argBuilders:     b,
+		defaultSettings: ds,
+		settings:        settings,
+		options:         o,
+		roles:           roles,
+		rateLimit:       rateLimit,
+		concurrency:     concurrency,
+		ipFilter:        ipFilter,
+	}
+	handler.compile()
+	http.Handle(path+"/"+name, handler)
 
-	return true
+	return handler
 }
 
 var types = make(map[string]func(string) Arg)
@@ -80,6 +474,16 @@ func AddType(t string, f func(string) Arg) bool {
 	return true
 }
 
+var typeSchemas = make(map[string]map[string]interface{})
+
+// AddTypeSchema is AddType for a type with a JSON Schema describing the values
+// it accepts, like {"type": "integer", "minimum": 1}, used by Methods and
+// OpenAPI. Always returns true.
+func AddTypeSchema(t string, f func(string) Arg, schema map[string]interface{}) bool {
+	typeSchemas[t] = schema
+	return AddType(t, f)
+}
+
 func GetHandlerForPattern(p string) http.Handler {
 	return handlers[p]
 }
@@ -0,0 +1,114 @@
+package httpize
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+type queryParam struct {
+	key, value string
+}
+
+// query is the parameters of the query part of a URL in order. Parsing it
+// only allocates the slice, and keys and values that need unescaping.
+type query []queryParam
+
+// parseQuery parses raw like url.ParseQuery.
+func parseQuery(raw string) (query, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	q := make(query, 0, strings.Count(raw, "&")+1)
+	for raw != "" {
+		var kv string
+		kv, raw, _ = strings.Cut(raw, "&")
+		if strings.Contains(kv, ";") {
+			return nil, errors.New("invalid semicolon separator in query")
+		}
+		if kv == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(kv, "=")
+		k, err := unescape(k)
+		if err != nil {
+			return nil, err
+		}
+		v, err = unescape(v)
+		if err != nil {
+			return nil, err
+		}
+		q = append(q, queryParam{k, v})
+	}
+	return q, nil
+}
+
+// countParams returns the number of parameters in raw, the non-empty parts
+// separated by & or ;.
+func countParams(raw string) int {
+	n := 0
+	for raw != "" {
+		i := strings.IndexAny(raw, "&;")
+		if i < 0 {
+			return n + 1
+		}
+		if i > 0 {
+			n++
+		}
+		raw = raw[i+1:]
+	}
+	return n
+}
+
+func unescape(s string) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+	return url.QueryUnescape(s)
+}
+
+// get returns the first value of key, or the last if last is true.
+func (q query) get(key string, last bool) (string, bool) {
+	v, found := "", false
+	for i := range q {
+		if q[i].key == key {
+			if !last {
+				return q[i].value, true
+			}
+			v, found = q[i].value, true
+		}
+	}
+	return v, found
+}
+
+// count returns how many times key is given.
+func (q query) count(key string) int {
+	n := 0
+	for i := range q {
+		if q[i].key == key {
+			n++
+		}
+	}
+	return n
+}
+
+// del removes key.
+func (q *query) del(key string) {
+	n := 0
+	for _, p := range *q {
+		if p.key != key {
+			(*q)[n] = p
+			n++
+		}
+	}
+	*q = (*q)[:n]
+}
+
+// values returns q as url.Values.
+func (q query) values() url.Values {
+	v := make(url.Values, len(q))
+	for _, p := range q {
+		v[p.key] = append(v[p.key], p.value)
+	}
+	return v
+}
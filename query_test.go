@@ -0,0 +1,47 @@
+package httpize
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	for _, raw := range []string{"", "a=1", "a=1&b=2&a=3", "a", "a=&=b", "&&a=1&", "a+b=c%20d", "k=%E2%9C%93"} {
+		q, err := parseQuery(raw)
+		if err != nil {
+			t.Fatalf("%q: %v", raw, err)
+		}
+		want, _ := url.ParseQuery(raw)
+		if got := q.values(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, want %v", raw, got, want)
+		}
+	}
+	for _, raw := range []string{"a=1;b=2", "a=%zz", "%=1"} {
+		if _, err := parseQuery(raw); err == nil {
+			t.Errorf("%q: expected error", raw)
+		}
+	}
+
+	q, _ := parseQuery("a=1&b=2&a=3")
+	if v, _ := q.get("a", false); v != "1" {
+		t.Errorf("first a = %q", v)
+	}
+	if v, _ := q.get("a", true); v != "3" {
+		t.Errorf("last a = %q", v)
+	}
+	if q.count("a") != 2 {
+		t.Errorf("count a = %d", q.count("a"))
+	}
+	q.del("a")
+	if _, ok := q.get("a", false); ok || len(q) != 1 {
+		t.Errorf("after del: %v", q)
+	}
+}
+
+func TestParseQueryAllocs(t *testing.T) {
+	raw := "a=1&b=two&c=3"
+	if n := testing.AllocsPerRun(100, func() { parseQuery(raw) }); n > 1 {
+		t.Errorf("parseQuery allocs = %v, want <= 1", n)
+	}
+}
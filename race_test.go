@@ -0,0 +1,7 @@
+//go:build race
+
+package httpize
+
+// sync.Pool drops items at random with the race detector, so allocations
+// aren't checked.
+const raceEnabled = true
@@ -0,0 +1,136 @@
+package httpize
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the rate of requests with a token bucket per key, eg per
+// client IP. Requests over the limit get a 429 error with Retry-After. Set it
+// in Options.RateLimit to limit all handlers using the Options together, or
+// use WithRateLimit for one method. Create with NewRateLimit.
+type RateLimit struct {
+	rate  float64
+	burst float64
+	key   func(*http.Request) string
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimit returns a RateLimit allowing rate requests a second on
+// average, and up to burst at once, per key returned by key. If key is nil
+// ClientIP is used.
+func NewRateLimit(rate float64, burst int, key func(*http.Request) string) *RateLimit {
+	if key == nil {
+		key = ClientIP
+	}
+	return &RateLimit{
+		rate:    rate,
+		burst:   float64(burst),
+		key:     key,
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+		now:     time.Now,
+	}
+}
+
+// ClientIP returns the IP address of the client. If the handler has an
+// IPFilter it is the address the IPFilter works out, so that requests from
+// its trusted proxies are keyed by the client they were forwarded for.
+// NewIPFilter(nil, nil, trusted) makes an IPFilter that only does that.
+// Otherwise it is from req.RemoteAddr.
+func ClientIP(req *http.Request) string {
+	if a, ok := req.Context().Value(clientAddrKey{}).(netip.Addr); ok {
+		return a.String()
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// PrincipalKey can be used as the key of a RateLimit to limit each
+// authenticated Principal, eg an API key, separately. Unauthenticated
+// requests are limited by ClientIP.
+func PrincipalKey(req *http.Request) string {
+	if p := PrincipalOf(req); p != nil {
+		return "principal:" + p.Name()
+	}
+	return "ip:" + ClientIP(req)
+}
+
+// allow takes a token from the bucket of req, if there is none it returns
+// false and how long until there will be.
+func (l *RateLimit) allow(req *http.Request) (bool, time.Duration) {
+	key := l.key(req)
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) >= time.Minute {
+		l.sweep(now)
+		l.swept = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{l.burst, now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes buckets that have refilled, as they are the same as new ones.
+// It is done once a minute.
+func (l *RateLimit) sweep(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// WithRateLimit returns a Caller for c to be given to Handle, requests to the
+// handler are limited by l, as well as Options.RateLimit. Optional interfaces
+// c implements are still used by the handler.
+func WithRateLimit(c Caller, l *RateLimit) Caller {
+	return rateLimitCaller{c, l}
+}
+
+type rateLimitCaller struct {
+	Caller
+	limit *RateLimit
+}
+
+// checkRateLimits returns a 429 error if req is over a rate limit.
+func (h *handler) checkRateLimits(req *http.Request) error {
+	for _, l := range []*RateLimit{h.options.RateLimit, h.rateLimit} {
+		if l == nil {
+			continue
+		}
+		if ok, wait := l.allow(req); !ok {
+			return RetryableError{429, "too many requests", wait}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package httpize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testRateLimit = NewRateLimit(1, 2, nil)
+
+var _ = Handle("/RateLimited", WithRateLimit(CallerFunc(NilSettings), testRateLimit))
+
+func TestRateLimit(t *testing.T) {
+	h := GetHandlerForPattern("/RateLimited")
+	now := time.Unix(1000, 0)
+	testRateLimit.now = func() time.Time { return now }
+
+	get := func(addr string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/RateLimited", nil)
+		request.RemoteAddr = addr
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	checkCode(t, get("10.0.0.1:1"), 200)
+	checkCode(t, get("10.0.0.1:2"), 200)
+	recorder := get("10.0.0.1:3")
+	checkCode(t, recorder, 429)
+	if recorder.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Retry-After %s", recorder.Header().Get("Retry-After"))
+	}
+	checkCode(t, get("10.0.0.2:1"), 200)
+
+	now = now.Add(time.Second)
+	checkCode(t, get("10.0.0.1:4"), 200)
+	checkCode(t, get("10.0.0.1:5"), 429)
+}
+
+var proxiedRateLimit = NewRateLimit(1, 1, nil)
+
+var trustedProxy, _ = NewIPFilter(nil, nil, []string{"192.168.0.1"})
+
+var _ = HandleWithOptions("/Proxied/RateLimited", CallerFunc(NilSettings), &Options{IPFilter: trustedProxy, RateLimit: proxiedRateLimit})
+
+func TestRateLimitProxied(t *testing.T) {
+	h := GetHandlerForPattern("/Proxied/RateLimited")
+	now := time.Unix(1000, 0)
+	proxiedRateLimit.now = func() time.Time { return now }
+	proxiedRateLimit.swept = now
+	proxiedRateLimit.buckets = make(map[string]*bucket)
+
+	get := func(forwarded string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/Proxied/RateLimited", nil)
+		request.RemoteAddr = "192.168.0.1:1234"
+		request.Header.Set("X-Forwarded-For", forwarded)
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	checkCode(t, get("8.8.8.8"), 200)
+	checkCode(t, get("8.8.4.4"), 200)
+	checkCode(t, get("8.8.8.8"), 429)
+
+	now = now.Add(2 * time.Minute)
+	checkCode(t, get("1.1.1.1"), 200)
+	if len(proxiedRateLimit.buckets) != 1 {
+		t.Fatalf("%d buckets after sweep", len(proxiedRateLimit.buckets))
+	}
+}
@@ -0,0 +1,39 @@
+package httpize
+
+import (
+	"net/http"
+)
+
+// SecurityHeaders are common security related response headers. They can be
+// set for a handler with Options.Security and for a response with
+// Settings.Security, which replaces those of the handler.
+type SecurityHeaders struct {
+	// Send X-Content-Type-Options: nosniff
+	NoSniff bool
+	// X-Frame-Options header, eg "DENY" or "SAMEORIGIN"
+	FrameOptions string
+	// Strict-Transport-Security header, eg "max-age=63072000"
+	StrictTransportSecurity string
+	// Content-Security-Policy header
+	ContentSecurityPolicy string
+}
+
+// set sets the headers in h, headers for empty fields are removed.
+func (s *SecurityHeaders) set(h http.Header) {
+	nosniff := ""
+	if s.NoSniff {
+		nosniff = "nosniff"
+	}
+	for k, v := range map[string]string{
+		"X-Content-Type-Options":    nosniff,
+		"X-Frame-Options":           s.FrameOptions,
+		"Strict-Transport-Security": s.StrictTransportSecurity,
+		"Content-Security-Policy":   s.ContentSecurityPolicy,
+	} {
+		if v == "" {
+			h.Del(k)
+		} else {
+			h.Set(k, v)
+		}
+	}
+}
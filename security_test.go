@@ -0,0 +1,39 @@
+package httpize
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var securityOptions = &Options{Security: &SecurityHeaders{NoSniff: true, FrameOptions: "DENY"}}
+
+var _ = HandleWithOptions("/Secure", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return bytes.NewBufferString("secure"), nil, nil
+}), securityOptions)
+
+var _ = HandleWithOptions("/Framed", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	s := NewSettings()
+	s.Security = &SecurityHeaders{NoSniff: true, ContentSecurityPolicy: "default-src 'self'"}
+	return bytes.NewBufferString("framed"), s, nil
+}), securityOptions)
+
+func TestSecurityHeaders(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Secure", nil)
+	GetHandlerForPattern("/Secure").ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Header().Get("X-Content-Type-Options") != "nosniff" || recorder.Header().Get("X-Frame-Options") != "DENY" {
+		t.Fatal("security headers missing")
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Framed", nil)
+	GetHandlerForPattern("/Framed").ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Header().Get("X-Frame-Options") != "" || recorder.Header().Get("Content-Security-Policy") != "default-src 'self'" {
+		t.Fatal("Settings.Security not used")
+	}
+}
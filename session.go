@@ -0,0 +1,144 @@
+package httpize
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// SessionStore loads and saves the session values of requests, set it in
+// Options.Sessions to use arguments of type Session.
+type SessionStore interface {
+	// Load returns the values of the session of req, empty if it has none
+	Load(req *http.Request) (map[string]string, error)
+	// Save saves values as the session of req, setting cookies on resp
+	Save(resp http.ResponseWriter, req *http.Request, values map[string]string) error
+}
+
+// Session is the type of arguments declared with type Session in a handler
+// pattern. It holds the session of the request loaded from
+// Options.Sessions. If it is changed by the Caller it is saved before the
+// response is written, unless the Caller returns an error.
+type Session struct {
+	mu      sync.Mutex
+	values  map[string]string
+	changed bool
+}
+
+func (s *Session) Check() error {
+	return nil
+}
+
+// Get returns the value of key, or "" if not set.
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// Set sets the value of key.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.changed = true
+}
+
+// Delete removes key.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.changed = true
+}
+
+// CookieSessionStore is a SessionStore keeping session values in a cookie
+// encrypted and authenticated with AES-GCM.
+type CookieSessionStore struct {
+	// Name of the cookie, "session" if ""
+	Name string
+	// AES key of 16, 24 or 32 bytes
+	Key []byte
+	// Max-Age of the cookie in seconds, if 0 the cookie lasts until the
+	// browser is closed
+	MaxAge int
+}
+
+func (s *CookieSessionStore) name() string {
+	if s.Name == "" {
+		return "session"
+	}
+	return s.Name
+}
+
+func (s *CookieSessionStore) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Load returns the values in the cookie, or empty values if there is no
+// cookie or it can not be decrypted.
+func (s *CookieSessionStore) Load(req *http.Request) (map[string]string, error) {
+	values := make(map[string]string)
+	cookie, err := req.Cookie(s.name())
+	if err != nil {
+		return values, nil
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(data) < aead.NonceSize() {
+		return values, nil
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(s.name()))
+	if err != nil || json.Unmarshal(plain, &values) != nil {
+		return make(map[string]string), nil
+	}
+	return values, nil
+}
+
+// Save sets the cookie to values, or deletes it if values is empty.
+func (s *CookieSessionStore) Save(resp http.ResponseWriter, req *http.Request, values map[string]string) error {
+	cookie := &http.Cookie{
+		Name:     s.name(),
+		Path:     "/",
+		MaxAge:   s.MaxAge,
+		Secure:   req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if len(values) == 0 {
+		cookie.MaxAge = -1
+		http.SetCookie(resp, cookie)
+		return nil
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	cookie.Value = base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(s.name())))
+	if len(cookie.Value) > 4000 {
+		return errors.New("httpize: session too large for cookie")
+	}
+	http.SetCookie(resp, cookie)
+	return nil
+}
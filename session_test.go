@@ -0,0 +1,54 @@
+package httpize
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var sessionOptions = &Options{Sessions: &CookieSessionStore{Key: []byte("0123456789abcdef")}}
+
+func Visits(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	s := args["s"].(*Session)
+	s.Set("visits", s.Get("visits")+"x")
+	return strings.NewReader(s.Get("visits")), nil, nil
+}
+
+var _ = HandleWithOptions("/Visits?s Session", CallerFunc(Visits), sessionOptions)
+
+func TestSession(t *testing.T) {
+	h := GetHandlerForPattern("/Visits?s Session")
+
+	var cookies []*http.Cookie
+	for _, want := range []string{"x", "xx", "xxx"} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host/Visits", nil)
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 200)
+		if recorder.Body.String() != want {
+			t.Fatalf("got %s, want %s", recorder.Body.String(), want)
+		}
+		cookies = recorder.Result().Cookies()
+		if len(cookies) != 1 || !cookies[0].HttpOnly || strings.Contains(cookies[0].Value, "visits") {
+			t.Fatalf("session cookie %v", cookies)
+		}
+	}
+
+	// tampered cookie starts a new session
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Visits", nil)
+	tampered := "A"
+	if cookies[0].Value[0] == 'A' {
+		tampered = "B"
+	}
+	request.AddCookie(&http.Cookie{Name: "session", Value: tampered + cookies[0].Value[1:]})
+	h.ServeHTTP(recorder, request)
+	if recorder.Body.String() != "x" {
+		t.Fatalf("got %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,139 @@
+package httpize
+
+import (
+	"compress/gzip"
+	"errors"
+	"net/http"
+)
+
+// NewSettings returns new Settings set as per SetToDefault.
+func NewSettings() *Settings {
+	s := new(Settings)
+	s.SetToDefault()
+	return s
+}
+
+// Clone returns a copy of s that shares nothing with s, so it can be changed
+// without changing s. If s is nil it returns NewSettings().
+func (s *Settings) Clone() *Settings {
+	if s == nil {
+		return NewSettings()
+	}
+	c := *s
+	if s.Headers != nil {
+		c.Headers = s.Headers.Clone()
+	}
+	c.Negotiate = append([]string(nil), s.Negotiate...)
+	c.Vary = append([]string(nil), s.Vary...)
+	c.Cookies = append([]*http.Cookie(nil), s.Cookies...)
+	if s.Security != nil {
+		security := *s.Security
+		c.Security = &security
+	}
+	return &c
+}
+
+// Validate returns an error if s has settings that can not be used together
+// or are invalid. It is called on Settings given to WithSettings and in
+// Options.Settings when handlers are added.
+func (s *Settings) Validate() error {
+	if s.StatusCode != 0 && (s.StatusCode < 100 || s.StatusCode > 599) {
+		return errors.New("httpize: StatusCode not between 100 and 599")
+	}
+	if s.Cache > 0 && (s.NoStore || s.NoCache) {
+		return errors.New("httpize: Cache set with NoStore or NoCache")
+	}
+	if s.CachePrivate && s.CachePublic {
+		return errors.New("httpize: CachePrivate set with CachePublic")
+	}
+	if (s.Gzip || s.Compress) && !new(Options).compressible(s.ContentType) {
+		return errors.New("httpize: compression set for already compressed ContentType " + s.ContentType)
+	}
+	if s.Encode != "" {
+		if _, ok := lookupEncoder(s.Encode); !ok {
+			return errors.New("httpize: unknown Encode " + s.Encode)
+		}
+	}
+	for _, e := range s.Negotiate {
+		if _, ok := lookupEncoder(e); !ok {
+			return errors.New("httpize: unknown Negotiate encoding " + e)
+		}
+	}
+	if s.AutoETag && s.Sendfile {
+		return errors.New("httpize: AutoETag set with Sendfile")
+	}
+	if s.GzipLevel < gzip.HuffmanOnly || s.GzipLevel > gzip.BestCompression {
+		return errors.New("httpize: invalid GzipLevel")
+	}
+	if s.BufferSize < 0 || s.CompressMinSize < 0 || s.MaxResponseSize < 0 || s.Cache < 0 {
+		return errors.New("httpize: negative size or time")
+	}
+	return nil
+}
+
+// The With methods return a clone of s with a field changed, they allow
+// deriving Settings from shared Settings like:
+//
+//	var jsonSettings = httpize.NewSettings().WithEncode("json")
+//	...
+//	return writerTo, jsonSettings.WithCache(300).WithGzip(true), nil
+
+// WithCache returns a clone of s with Cache set to seconds.
+func (s *Settings) WithCache(seconds int64) *Settings {
+	c := s.Clone()
+	c.Cache = seconds
+	return c
+}
+
+// WithContentType returns a clone of s with ContentType set.
+func (s *Settings) WithContentType(contentType string) *Settings {
+	c := s.Clone()
+	c.ContentType = contentType
+	return c
+}
+
+// WithGzip returns a clone of s with Gzip set.
+func (s *Settings) WithGzip(gzip bool) *Settings {
+	c := s.Clone()
+	c.Gzip = gzip
+	return c
+}
+
+// WithCompress returns a clone of s with Compress set.
+func (s *Settings) WithCompress(compress bool) *Settings {
+	c := s.Clone()
+	c.Compress = compress
+	return c
+}
+
+// WithStatusCode returns a clone of s with StatusCode set.
+func (s *Settings) WithStatusCode(code int) *Settings {
+	c := s.Clone()
+	c.StatusCode = code
+	return c
+}
+
+// WithEncode returns a clone of s with Encode set.
+func (s *Settings) WithEncode(encode string) *Settings {
+	c := s.Clone()
+	c.Encode = encode
+	return c
+}
+
+// WithCookie returns a clone of s with cookie added to Cookies.
+func (s *Settings) WithCookie(cookie *http.Cookie) *Settings {
+	c := s.Clone()
+	c.Cookies = append(c.Cookies, cookie)
+	return c
+}
+
+// WithHeader returns a clone of s with the header key set to value in
+// Headers.
+func (s *Settings) WithHeader(key, value string) *Settings {
+	c := s.Clone()
+	if c.Headers == nil {
+		c.Headers = make(http.Header)
+	}
+	c.Headers.Set(key, value)
+	return c
+}
@@ -0,0 +1,88 @@
+package httpize
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSettingsWith(t *testing.T) {
+	shared := NewSettings().WithHeader("X-A", "a")
+	derived := shared.WithGzip(true).WithCache(300).WithHeader("X-B", "b")
+
+	if shared.Gzip || shared.Cache != 0 || shared.Headers.Get("X-B") != "" {
+		t.Fatal("shared Settings changed")
+	}
+	if !derived.Gzip || derived.Cache != 300 || derived.ContentType != "text/html" {
+		t.Fatal("derived Settings incorrect")
+	}
+	if derived.Headers.Get("X-A") != "a" || derived.Headers.Get("X-B") != "b" {
+		t.Fatal("derived Headers incorrect")
+	}
+
+	var s *Settings
+	if c := s.WithStatusCode(201); c.StatusCode != 201 || c.ContentType != "text/html" {
+		t.Fatal("nil Settings not cloned as default")
+	}
+}
+
+func TestSettingsCookies(t *testing.T) {
+	shared := NewSettings().WithCookie(&http.Cookie{Name: "a", Value: "1"})
+	derived := shared.WithCookie(&http.Cookie{Name: "b", Value: "2"})
+	if len(shared.Cookies) != 1 || len(derived.Cookies) != 2 {
+		t.Fatal("Cookies incorrect")
+	}
+}
+
+var _ = Handle("/Login", CallerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	s := NewSettings().WithCookie(&http.Cookie{Name: "session", Value: "abc", HttpOnly: true})
+	return bytes.NewBufferString("logged in"), s, nil
+}))
+
+func TestCookies(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "http://host/Login", nil)
+	GetHandlerForPattern("/Login").ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session" || cookies[0].Value != "abc" || !cookies[0].HttpOnly {
+		t.Fatal("cookie not set")
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	for _, s := range []*Settings{
+		NewSettings().WithStatusCode(201),
+		NewSettings().WithCache(60).WithHeader("X", "y"),
+		NewSettings().WithContentType("image/png"),
+		NewSettings().WithEncode("json"),
+	} {
+		if err := s.Validate(); err != nil {
+			t.Fatalf("valid Settings: %s", err)
+		}
+	}
+
+	invalid := []*Settings{
+		NewSettings().WithStatusCode(600),
+		NewSettings().WithStatusCode(99),
+		NewSettings().WithContentType("image/png").WithGzip(true),
+		NewSettings().WithEncode("yaml"),
+		{Cache: 60, NoStore: true},
+		{AutoETag: true, Sendfile: true},
+	}
+	for i, s := range invalid {
+		if s.Validate() == nil {
+			t.Fatalf("invalid Settings %d validated", i)
+		}
+	}
+}
+
+var _ = Handle("/InvalidSettings", WithSettings(CallerFunc(nil), NewSettings().WithStatusCode(1000)))
+
+func TestInvalidSettingsNotHandled(t *testing.T) {
+	if GetHandlerForPattern("/InvalidSettings") != nil {
+		t.Fatal("handler with invalid Settings added")
+	}
+}
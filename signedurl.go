@@ -0,0 +1,55 @@
+package httpize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// URLSigner makes and verifies URLs signed with HMAC-SHA256 that expire, eg
+// for links to download a file that can be shared. Set it in
+// Options.SignedURLs to make handlers only accept URLs signed by it.
+type URLSigner struct {
+	Key []byte
+}
+
+// SignURL returns path with args as the query, and the parameters expires and
+// signature added, making the URL valid for ttl. path is like the path of a
+// handler pattern, eg "/files/Download".
+func (s *URLSigner) SignURL(path string, args url.Values, ttl time.Duration) string {
+	q := make(url.Values, len(args)+2)
+	for k, v := range args {
+		q[k] = v
+	}
+	q.Set("expires", strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
+	q.Set("signature", s.sign(path, q))
+	return path + "?" + q.Encode()
+}
+
+// sign returns the signature of path and q without a signature parameter.
+func (s *URLSigner) sign(path string, q url.Values) string {
+	sig := q["signature"]
+	q.Del("signature")
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write([]byte(path + "?" + q.Encode()))
+	if sig != nil {
+		q["signature"] = sig
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns a 403 error if q does not have a valid signature for path or
+// has expired.
+func (s *URLSigner) verify(path string, q url.Values) error {
+	if !hmac.Equal([]byte(q.Get("signature")), []byte(s.sign(path, q))) {
+		return Non500Error{403, "invalid URL signature", ""}
+	}
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return Non500Error{403, "URL expired", ""}
+	}
+	return nil
+}
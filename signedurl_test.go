@@ -0,0 +1,34 @@
+package httpize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSigner = &URLSigner{Key: []byte("secret")}
+
+var _ = HandleWithOptions("/files/Download", CallerFunc(NilSettings), &Options{SignedURLs: testSigner, IgnoreUnknown: true})
+
+func TestSignedURL(t *testing.T) {
+	h := GetHandlerForPattern("/files/Download")
+
+	valid := testSigner.SignURL("/files/Download", url.Values{"name": {"a.txt"}}, time.Minute)
+	for u, code := range map[string]int{
+		valid: 200,
+		strings.Replace(valid, "a.txt", "b.txt", 1):                                        403,
+		testSigner.SignURL("/files/Download", url.Values{"name": {"a.txt"}}, -time.Minute): 403,
+		"/files/Download?name=a.txt":                                                       403,
+		(&URLSigner{Key: []byte("other")}).SignURL("/files/Download", nil, time.Minute):    403,
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host"+u, nil)
+		h.ServeHTTP(recorder, request)
+		if recorder.Code != code {
+			t.Fatalf("%s: got %d", u, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package httpize
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// MountStatic adds a handler serving the files in fsys under prefix, which is
+// "" or like "/app". Handlers added with Handle take priority as their paths
+// are more specific. Paths not in fsys get fsys's index.html, so that client
+// side routing of a single page app works, unless they have a file extension,
+// which get a 404 error. Always returns true.
+func MountStatic(prefix string, fsys fs.FS) bool {
+	files := http.FileServerFS(fsys)
+	http.Handle(prefix+"/", http.StripPrefix(prefix, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(path.Clean(req.URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
+		if _, err := fs.Stat(fsys, name); errors.Is(err, fs.ErrNotExist) && path.Ext(name) == "" {
+			http.ServeFileFS(resp, req, fsys, "index.html")
+			return
+		}
+		files.ServeHTTP(resp, req)
+	})))
+	return true
+}
@@ -0,0 +1,44 @@
+package httpize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+var _ = MountStatic("/app", fstest.MapFS{
+	"index.html":    {Data: []byte("index")},
+	"js/app.js":     {Data: []byte("app")},
+	"css/style.css": {Data: []byte("style")},
+})
+
+var _ = Handle("/app/Status", CallerFunc(NilSettings))
+
+func TestMountStatic(t *testing.T) {
+	for _, c := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/app/", 200, "index"},
+		{"/app/js/app.js", 200, "app"},
+		{"/app/users/1", 200, "index"},
+		{"/app/missing.js", 404, ""},
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "http://host"+c.path, nil)
+		http.DefaultServeMux.ServeHTTP(recorder, request)
+		checkCode(t, recorder, c.code)
+		if c.body != "" && recorder.Body.String() != c.body {
+			t.Errorf("%s: got %q", c.path, recorder.Body.String())
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/app/Status", nil)
+	http.DefaultServeMux.ServeHTTP(recorder, request)
+	if recorder.Body.String() == "index" {
+		t.Error("method not given priority")
+	}
+}
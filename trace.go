@@ -0,0 +1,52 @@
+package httpize
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer starts a span for each request, set it in Options.Tracer. It can be
+// used to adapt a tracing library such as OpenTelemetry without httpize
+// depending on it.
+type Tracer interface {
+	// Start starts a span named after method for req. The returned context,
+	// which should have any trace context propagated in the request headers,
+	// is the context of the request and so of the method call.
+	Start(req *http.Request, method string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetArgs is given the query parameter arguments of the call. Values
+	// are "[REDACTED]" unless Options.TraceArgValues is set and the
+	// argument doesn't have ArgDef.Redact set.
+	SetArgs(args map[string]string)
+	// SetError is given the error returned by the method, if any.
+	SetError(err error)
+	// End is called once the response has been written with its status code.
+	End(status int)
+}
+
+type spanKey struct{}
+
+// spanOf returns the Span of req, or nil.
+func spanOf(req *http.Request) Span {
+	s, _ := req.Context().Value(spanKey{}).(Span)
+	return s
+}
+
+// traced wraps next to start a span with Options.Tracer for requests.
+func (h *handler) traced(next http.Handler) http.Handler {
+	tracer := h.options.Tracer
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req, h.name)
+		sw := &statusWriter{ResponseWriter: resp}
+		defer func() {
+			if sw.code == 0 {
+				sw.code = http.StatusOK
+			}
+			span.End(sw.code)
+		}()
+		next.ServeHTTP(sw, req.WithContext(context.WithValue(ctx, spanKey{}, span)))
+	})
+}
@@ -0,0 +1,81 @@
+package httpize
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type traceKey struct{}
+
+type testSpan struct {
+	name   string
+	args   map[string]string
+	err    error
+	status int
+}
+
+func (s *testSpan) SetArgs(args map[string]string) { s.args = args }
+func (s *testSpan) SetError(err error)             { s.err = err }
+func (s *testSpan) End(status int)                 { s.status = status }
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(req *http.Request, method string) (context.Context, Span) {
+	s := &testSpan{name: method}
+	t.spans = append(t.spans, s)
+	return context.WithValue(req.Context(), traceKey{}, req.Header.Get("traceparent")), s
+}
+
+type tracedFunc struct{}
+
+func (tracedFunc) Call(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return nil, nil, errors.New("Call used instead of CallContext")
+}
+
+func (tracedFunc) CallContext(ctx context.Context, args map[string]Arg) (io.WriterTo, *Settings, error) {
+	if args["fail"] != nil {
+		return nil, nil, Non500Error{409, "conflict", ""}
+	}
+	return strings.NewReader(ctx.Value(traceKey{}).(string)), nil, nil
+}
+
+var tracer = new(testTracer)
+
+var _ = HandleWithOptions("/Traced?name SafeString&fail SafeString=", tracedFunc{}, &Options{Tracer: tracer})
+
+func TestTracer(t *testing.T) {
+	h := GetHandlerForPattern("/Traced?name SafeString&fail SafeString=")
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/Traced?name=secret", nil)
+	request.Header.Set("traceparent", "00-trace-span-01")
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 200)
+	if recorder.Body.String() != "00-trace-span-01" {
+		t.Fatalf("trace context not propagated, got %q", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "http://host/Traced?name=x&fail=1", nil)
+	h.ServeHTTP(recorder, request)
+	checkCode(t, recorder, 409)
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("got %d spans", len(tracer.spans))
+	}
+	s := tracer.spans[0]
+	if s.name != "Traced" || s.status != 200 || s.err != nil || s.args["name"] != "[REDACTED]" {
+		t.Errorf("got %+v", s)
+	}
+	s = tracer.spans[1]
+	if s.status != 409 || s.err == nil {
+		t.Errorf("got %+v", s)
+	}
+}
@@ -0,0 +1,82 @@
+package httpize
+
+import (
+	"fmt"
+	"io"
+)
+
+// Func0 returns a Caller calling f, for handlers with no arguments.
+func Func0(f func() (io.WriterTo, *Settings, error)) Caller {
+	return callerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+		return f()
+	})
+}
+
+// Func1 returns a Caller calling f with the argument with key k1 as its
+// parameter, so f does not have to get arguments from the map and type assert
+// them. If the argument is optional and not given f is passed the zero value
+// of T1. An argument with a type other than T1 causes a 500 error.
+func Func1[T1 Arg](f func(T1) (io.WriterTo, *Settings, error), k1 string) Caller {
+	return callerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+		a1, err := typedArg[T1](args, k1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f(a1)
+	})
+}
+
+// Func2 is like Func1 for handlers with two arguments.
+func Func2[T1, T2 Arg](f func(T1, T2) (io.WriterTo, *Settings, error), k1, k2 string) Caller {
+	return callerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+		a1, err := typedArg[T1](args, k1)
+		if err != nil {
+			return nil, nil, err
+		}
+		a2, err := typedArg[T2](args, k2)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f(a1, a2)
+	})
+}
+
+// Func3 is like Func1 for handlers with three arguments.
+func Func3[T1, T2, T3 Arg](f func(T1, T2, T3) (io.WriterTo, *Settings, error), k1, k2, k3 string) Caller {
+	return callerFunc(func(args map[string]Arg) (io.WriterTo, *Settings, error) {
+		a1, err := typedArg[T1](args, k1)
+		if err != nil {
+			return nil, nil, err
+		}
+		a2, err := typedArg[T2](args, k2)
+		if err != nil {
+			return nil, nil, err
+		}
+		a3, err := typedArg[T3](args, k3)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f(a1, a2, a3)
+	})
+}
+
+// typedArg returns the argument with key k as a T, or the zero value of T if
+// it is not in args.
+func typedArg[T Arg](args map[string]Arg, k string) (T, error) {
+	var t T
+	a, ok := args[k]
+	if !ok {
+		return t, nil
+	}
+	t, ok = a.(T)
+	if !ok {
+		return t, fmt.Errorf("httpize: argument %s is %T not %T", k, a, t)
+	}
+	return t, nil
+}
+
+type callerFunc func(map[string]Arg) (io.WriterTo, *Settings, error)
+
+func (f callerFunc) Call(args map[string]Arg) (io.WriterTo, *Settings, error) {
+	return f(args)
+}
@@ -0,0 +1,44 @@
+package httpize
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func typedGreet(name SafeString, greeting SafeString) (io.WriterTo, *Settings, error) {
+	if greeting == "" {
+		greeting = "Hello"
+	}
+	return strings.NewReader(string(greeting) + " " + string(name)), nil, nil
+}
+
+var _ = Handle("/TypedGreet?name SafeString&greeting SafeString=", Func2(typedGreet, "name", "greeting"))
+
+var _ = Handle("/TypedWrong?name SafeString", Func1(func(b Body) (io.WriterTo, *Settings, error) {
+	return nil, nil, nil
+}, "name"))
+
+func TestTypedFuncs(t *testing.T) {
+	h := GetHandlerForPattern("/TypedGreet?name SafeString&greeting SafeString=")
+
+	for url, body := range map[string]string{
+		"http://host/TypedGreet?name=bob":             "Hello bob",
+		"http://host/TypedGreet?name=bob&greeting=Hi": "Hi bob",
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", url, nil)
+		h.ServeHTTP(recorder, request)
+		checkCode(t, recorder, 200)
+		if recorder.Body.String() != body {
+			t.Fatalf("got %s", recorder.Body.String())
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://host/TypedWrong?name=bob", nil)
+	GetHandlerForPattern("/TypedWrong?name SafeString").ServeHTTP(recorder, request)
+	checkCode(t, recorder, 500)
+}